@@ -0,0 +1,76 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+// runDoctor checks the nodes and the StorageClasses against the Proxmox clusters and prints the findings.
+// It returns false if any finding is an error.
+//
+//	proxmox-csi-controller --cloud-config=config.yaml --kubeconfig=kubeconfig doctor [--output=json]
+func runDoctor(ctx context.Context, controllerService *csi.ControllerService, clientset clientkubernetes.Interface, out io.Writer, args []string) (bool, error) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	output := fs.String("output", "table", "The output format, table or json.")
+
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	if *output != "table" && *output != "json" {
+		return false, fmt.Errorf("unknown output format %s", *output)
+	}
+
+	findings, err := controllerService.Diagnose(ctx, clientset)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+
+	for _, f := range findings {
+		if f.Severity == csi.SeverityError {
+			ok = false
+		}
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+
+		return ok, enc.Encode(findings)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tKIND\tNAME\tMESSAGE") //nolint:errcheck
+
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Severity, f.Kind, f.Name, f.Message) //nolint:errcheck
+	}
+
+	return ok, w.Flush()
+}
@@ -19,15 +19,25 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"flag"
 	"net"
+	"net/http"
 	"os"
+	"time"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc"
 
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2"
 )
 
@@ -36,6 +46,18 @@ var (
 	csiEndpoint = flag.String("csi-address", "unix:///csi/csi.sock", "CSI Endpoint")
 	cloudconfig = flag.String("cloud-config", "", "The path to the CSI driver cloud config.")
 
+	operationTimeout = flag.Duration("operation-timeout", csi.DefaultOperationTimeout, "The timeout of the Proxmox API calls of one CSI request.")
+	configReload     = flag.Duration("cloud-config-reload-interval", csi.DefaultConfigReloadInterval, "The period of the cloud config and secret files checks, the changed config is applied without restart, disabled if 0.")
+	cacheTTL         = flag.Duration("cache-ttl", csi.DefaultCacheTTL, "The lifetime of the cached Proxmox node lists, storage lists, storage configs and storage content, disabled if 0.")
+	httpEndpoint     = flag.String("http-endpoint", "", "The address to serve the metrics on /debug/vars, for example :8080, disabled if empty.")
+
+	trashRetention = flag.Duration("trash-retention", 0, "Keep the deleted volumes in the trash for this period, disabled if 0.")
+	trashConfigMap = flag.String("trash-configmap", "proxmox-csi-trash", "The name of the ConfigMap to store the volume trash.")
+	trashNamespace = flag.String("trash-namespace", "", "The namespace of the trash ConfigMap, defaults to the NAMESPACE environment.")
+
+	master     = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+
 	version string
 )
 
@@ -44,6 +66,9 @@ func main() {
 	flag.Set("logtostderr", "true") //nolint: errcheck
 	flag.Parse()
 
+	// The passwords, token secrets and tickets of the Proxmox clusters are never logged
+	klog.SetLogFilter(proxmox.LogFilter{})
+
 	klog.V(2).Infof("Driver version %v, GitVersion %s", csi.DriverVersion, version)
 	klog.V(2).Info("Driver CSI Spec version: ", csi.DriverSpecVersion)
 
@@ -60,6 +85,82 @@ func main() {
 		klog.Fatalln("cloud-config must be provided")
 	}
 
+	controllerService, err := csi.NewControllerService(*cloudconfig)
+	if err != nil {
+		klog.Fatalf("Failed to create controller service: %v", err)
+	}
+
+	controllerService.OperationTimeout = *operationTimeout
+	controllerService.CacheTTL = *cacheTTL
+
+	if flag.Arg(0) == "check-permissions" {
+		ok, err := runCheckPermissions(context.Background(), controllerService, os.Stdout, flag.Args()[1:])
+		if err != nil {
+			klog.Fatalf("Failed to check permissions: %v", err)
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if flag.Arg(0) == "doctor" {
+		ok, err := runDoctor(context.Background(), controllerService, newKubeClient(), os.Stdout, flag.Args()[1:])
+		if err != nil {
+			klog.Fatalf("Failed to check the configuration: %v", err)
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	restore := flag.Arg(0) == "restore"
+
+	var (
+		clientset clientkubernetes.Interface
+		namespace string
+	)
+
+	if *trashRetention > 0 || restore {
+		clientset = newKubeClient()
+
+		namespace = *trashNamespace
+		if namespace == "" {
+			namespace = os.Getenv("NAMESPACE")
+
+			if namespace == "" {
+				klog.Fatalln("trash-namespace or NAMESPACE environment must be provided")
+			}
+		}
+
+		controllerService.Trash = csi.NewConfigMapTrash(clientset, namespace, *trashConfigMap)
+	}
+
+	if restore {
+		if err := runRestore(context.Background(), controllerService, clientset, flag.Args()[1:]); err != nil {
+			klog.Fatalf("Failed to restore volume: %v", err)
+		}
+
+		os.Exit(0)
+	}
+
+	if *trashRetention > 0 {
+		go runTrashPurge(clientset, namespace, controllerService, *trashRetention)
+	}
+
+	if *configReload > 0 {
+		go controllerService.WatchCloudConfig(context.Background(), *configReload)
+	}
+
+	if *httpEndpoint != "" {
+		go serveMetrics(*httpEndpoint)
+	}
+
 	scheme, addr, err := csi.ParseEndpoint(*csiEndpoint)
 	if err != nil {
 		klog.Fatalf("Failed to parse endpoint: %v", err)
@@ -86,11 +187,7 @@ func main() {
 	srv := grpc.NewServer(opts...)
 
 	identityService := csi.NewIdentityService()
-
-	controllerService, err := csi.NewControllerService(*cloudconfig)
-	if err != nil {
-		klog.Fatalf("Failed to create controller service: %v", err)
-	}
+	identityService.HealthCheck = controllerService.CloudConfigStatus
 
 	proto.RegisterControllerServer(srv, controllerService)
 	proto.RegisterIdentityServer(srv, identityService)
@@ -101,3 +198,109 @@ func main() {
 		klog.Fatalf("Failed to serve: %v", err)
 	}
 }
+
+func newKubeClient() clientkubernetes.Interface {
+	kubeconfigEnv := os.Getenv("KUBECONFIG")
+	if kubeconfigEnv != "" {
+		klog.Infof("Found KUBECONFIG environment variable set, using that..")
+
+		kubeconfig = &kubeconfigEnv
+	}
+
+	var (
+		config *rest.Config
+		err    error
+	)
+
+	if *master != "" || *kubeconfig != "" {
+		klog.Infof("Either master or kubeconfig specified. building kube config from that..")
+
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+		if err != nil {
+			klog.Fatal(err)
+		}
+	} else {
+		klog.Infof("Building kube configs for running in cluster...")
+
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+
+	clientset, err := clientkubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Fatalf("Failed to create client: %v", err)
+	}
+
+	return clientset
+}
+
+// runTrashPurge purges the trash only in the replica which holds the lease, so the replicas do not delete
+// the same volumes and do not rewrite the trash ConfigMap at the same time. The lease has the name of the ConfigMap.
+func runTrashPurge(clientset clientkubernetes.Interface, namespace string, controllerService *csi.ControllerService, retention time.Duration) {
+	identity, err := os.Hostname()
+	if err != nil {
+		klog.Fatalf("Failed to get hostname: %v", err)
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: *trashConfigMap, Namespace: namespace},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	for {
+		leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			ReleaseOnCancel: true,
+			LeaseDuration:   15 * time.Second,
+			RenewDeadline:   10 * time.Second,
+			RetryPeriod:     2 * time.Second,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.Infof("Purging the trash, the lease %s/%s is acquired", namespace, *trashConfigMap)
+
+					purgeTrash(ctx, controllerService, retention)
+				},
+				OnStoppedLeading: func() {
+					klog.Infof("Stopped purging the trash, the lease %s/%s is lost", namespace, *trashConfigMap)
+				},
+			},
+		})
+	}
+}
+
+func purgeTrash(ctx context.Context, controllerService *csi.ControllerService, retention time.Duration) {
+	// Check the trash more often than the retention period, but not too often
+	interval := min(max(retention/10, time.Minute), time.Hour)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := controllerService.PurgeTrash(ctx, retention); err != nil {
+			klog.Errorf("Failed to purge the trash: %v", err)
+		}
+	}
+}
+
+// serveMetrics serves the expvar metrics, the active endpoints of the Proxmox clusters among them.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	klog.Infof("Serving metrics on address: %s", addr)
+
+	if err := server.ListenAndServe(); err != nil {
+		klog.Fatalf("Failed to serve metrics: %v", err)
+	}
+}
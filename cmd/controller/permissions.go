@@ -0,0 +1,80 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+)
+
+// runCheckPermissions checks the privileges of the Proxmox tokens and prints the report.
+// It returns false if a required privilege is missing or a region cannot be checked.
+//
+//	proxmox-csi-controller --cloud-config=config.yaml check-permissions [--storage=local-lvm,rbd]
+func runCheckPermissions(ctx context.Context, controllerService *csi.ControllerService, out io.Writer, args []string) (bool, error) {
+	fs := flag.NewFlagSet("check-permissions", flag.ExitOnError)
+	storages := fs.String("storage", "", "Comma separated list of the storages to check, defaults to all storages with the disk images.")
+
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	var storageNames []string
+	if *storages != "" {
+		storageNames = strings.Split(*storages, ",")
+	}
+
+	reports := controllerService.CheckPermissions(ctx, storageNames)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REGION\tPATH\tPRIVILEGE\tSTATUS\tUSED FOR") //nolint:errcheck
+
+	ok := true
+
+	for _, report := range reports {
+		if !report.OK() {
+			ok = false
+		}
+
+		if report.Error != "" {
+			fmt.Fprintf(w, "%s\t\t\terror\t%s\n", report.Region, report.Error) //nolint:errcheck
+
+			continue
+		}
+
+		for _, c := range report.Checks {
+			status := "ok"
+
+			switch {
+			case !c.Granted && c.Optional:
+				status = "missing (optional)"
+			case !c.Granted:
+				status = "missing"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", report.Region, c.Path, c.Privilege, status, c.UsedFor) //nolint:errcheck
+		}
+	}
+
+	return ok, w.Flush()
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	proto "github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// runRestore restores the volume from the trash and creates a new PV for it.
+//
+//	proxmox-csi-controller --cloud-config=config.yaml restore --storage-class=proxmox-data <volume-id>
+func runRestore(ctx context.Context, controllerService *csi.ControllerService, clientset clientkubernetes.Interface, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	storageClass := fs.String("storage-class", "", "The StorageClass name of the new PV.")
+	fsType := fs.String("fs-type", csi.FSTypeExt4, "The filesystem type of the volume.")
+	pvName := fs.String("pv-name", "", "The name of the new PV, defaults to the PV name of the deleted volume.")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("volume id must be provided")
+	}
+
+	params := map[string]string{}
+
+	if *storageClass != "" {
+		sc, err := clientset.StorageV1().StorageClasses().Get(ctx, *storageClass, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get StorageClass %s: %v", *storageClass, err)
+		}
+
+		params = sc.Parameters
+	}
+
+	accessModes, err := controllerService.Trash.AccessModes(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// The volumes deleted by the older versions have no access modes in the trash
+	if len(accessModes) == 0 {
+		klog.Warningf("The access modes of volume %s are unknown, using %s", fs.Arg(0), corev1.ReadWriteOnce)
+
+		accessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+
+	vol, err := controllerService.RestoreVolume(ctx, fs.Arg(0), params)
+	if err != nil {
+		return err
+	}
+
+	// The volume stays in the trash on failure, so it is still purged if it is not restored again
+
+	// The PV name and the claim are read from the disk name, the volume of the unknown disk name template is not bound to a guessed claim
+	if *pvName == "" && vol.VolumeContext[csi.PVNameKey] == "" {
+		return fmt.Errorf("the PV name cannot be read from the disk name of volume %s, set --pv-name", vol.VolumeId)
+	}
+
+	pv := restorePersistentVolume(vol, *pvName, *storageClass, *fsType, accessModes)
+
+	if _, err = clientset.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PV %s: %v", pv.Name, err)
+	}
+
+	if err = controllerService.Trash.Remove(ctx, vol.VolumeId); err != nil {
+		return fmt.Errorf("failed to remove volume %s from the trash, remove it before the trash retention ends: %v", vol.VolumeId, err)
+	}
+
+	klog.Infof("Volume %s is restored as PV %s", vol.VolumeId, pv.Name)
+
+	return nil
+}
+
+func restorePersistentVolume(vol *proto.Volume, name, storageClass, fsType string, accessModes []corev1.PersistentVolumeAccessMode) *corev1.PersistentVolume {
+	if name == "" {
+		name = vol.VolumeContext[csi.PVNameKey]
+	}
+
+	terms := []corev1.NodeSelectorRequirement{}
+
+	for _, topology := range vol.AccessibleTopology {
+		for key, value := range topology.Segments {
+			terms = append(terms, corev1.NodeSelectorRequirement{
+				Key:      key,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{value},
+			})
+		}
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				"pv.kubernetes.io/provisioned-by": csi.DriverName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: accessModes,
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: *resource.NewQuantity(vol.CapacityBytes, resource.BinarySI),
+			},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:           csi.DriverName,
+					FSType:           fsType,
+					VolumeHandle:     vol.VolumeId,
+					VolumeAttributes: vol.VolumeContext,
+				},
+			},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+			StorageClassName:              storageClass,
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: terms}},
+				},
+			},
+		},
+	}
+
+	// Bind the PV to the PVC of the deleted volume, so the PVC with the same name can use it
+	if vol.VolumeContext[csi.PVCNamespaceKey] != "" && vol.VolumeContext[csi.PVCNameKey] != "" {
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  vol.VolumeContext[csi.PVCNamespaceKey],
+			Name:       vol.VolumeContext[csi.PVCNameKey],
+		}
+	}
+
+	return pv
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+const (
+	// DefaultCacheTTL is the default lifetime of the cached Proxmox API responses
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// apiCacheKey is the kind of the cached response and its node and storage, if any.
+type apiCacheKey struct {
+	kind    string
+	node    string
+	storage string
+}
+
+type apiCacheEntry struct {
+	data      map[string]interface{}
+	expiresAt time.Time
+}
+
+// apiCache caches the node list, the storage list and configs and the storage content of one region for a short time.
+// The storage content is invalidated after the plugin creates, deletes or resizes a volume on the storage,
+// the changes made by others are seen after the TTL. Only the successful responses are cached.
+// The nil cache is valid, it calls the Proxmox API every time.
+// The cached responses are shared, they must not be modified.
+type apiCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[apiCacheKey]apiCacheEntry
+	// generation is increased by each invalidation, the responses of the requests sent before it are not cached
+	generation uint64
+}
+
+func newAPICache(ttl time.Duration) *apiCache {
+	return &apiCache{ttl: ttl, entries: map[apiCacheKey]apiCacheEntry{}}
+}
+
+// getAPICache returns the API cache of the region, or nil if the cache is disabled.
+func (d *ControllerService) getAPICache(region string) *apiCache {
+	if d.CacheTTL <= 0 {
+		return nil
+	}
+
+	d.apiCacheLock.Lock()
+	defer d.apiCacheLock.Unlock()
+
+	if d.apiCaches == nil {
+		d.apiCaches = map[string]*apiCache{}
+	}
+
+	c, ok := d.apiCaches[region]
+	if !ok {
+		c = newAPICache(d.CacheTTL)
+		d.apiCaches[region] = c
+	}
+
+	return c
+}
+
+func (c *apiCache) get(ctx context.Context, key apiCacheKey, fetch func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if c == nil {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.data, nil
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.entries[key] = apiCacheEntry{data: data, expiresAt: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	return data, nil
+}
+
+func (c *apiCache) nodeList(ctx context.Context, cl *pxapi.Client) (map[string]interface{}, error) {
+	return c.get(ctx, apiCacheKey{kind: "nodes"}, cl.GetNodeList)
+}
+
+func (c *apiCache) storageList(ctx context.Context, cl *pxapi.Client) (map[string]interface{}, error) {
+	return c.get(ctx, apiCacheKey{kind: "storages"}, cl.GetStorageList)
+}
+
+func (c *apiCache) storageConfig(ctx context.Context, cl *pxapi.Client, storageName string) (map[string]interface{}, error) {
+	return c.get(ctx, apiCacheKey{kind: "storage", storage: storageName}, func() (map[string]interface{}, error) {
+		return cl.GetStorageConfig(storageName)
+	})
+}
+
+func (c *apiCache) storageContent(ctx context.Context, cl *pxapi.Client, node, storageName string) (map[string]interface{}, error) {
+	return c.get(ctx, apiCacheKey{kind: "content", node: node, storage: storageName}, func() (map[string]interface{}, error) {
+		vmr := pxapi.NewVmRef(vmID)
+		vmr.SetNode(node)
+		vmr.SetVmType("qemu")
+
+		return cl.GetStorageContent(vmr, storageName)
+	})
+}
+
+// invalidateContent drops the cached content of the storage, it must be called after the plugin changes the volumes of the storage.
+// The content is cached per node, and the shared storage has the same content on all nodes, so it is dropped on all nodes.
+func (c *apiCache) invalidateContent(storageName string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for key := range c.entries {
+		if key.kind == "content" && key.storage == storageName {
+			delete(c.entries, key)
+		}
+	}
+}
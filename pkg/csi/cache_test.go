@@ -0,0 +1,62 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPICacheInvalidate(t *testing.T) {
+	t.Parallel()
+
+	c := newAPICache(time.Minute)
+	key := apiCacheKey{kind: "content", node: "pve-1", storage: "local-lvm"}
+	calls := 0
+
+	fetch := func() (map[string]interface{}, error) {
+		calls++
+
+		return map[string]interface{}{"data": calls}, nil
+	}
+
+	// The volume is created while the content is read, the stale content is not cached
+	data, err := c.get(context.Background(), key, func() (map[string]interface{}, error) {
+		c.invalidateContent("local-lvm")
+
+		return fetch()
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"data": 1}, data)
+
+	data, err = c.get(context.Background(), key, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"data": 2}, data)
+
+	data, err = c.get(context.Background(), key, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"data": 2}, data)
+
+	c.invalidateContent("local-lvm")
+
+	data, err = c.get(context.Background(), key, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"data": 3}, data)
+}
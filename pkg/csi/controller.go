@@ -18,18 +18,24 @@ package csi
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	proxmox "github.com/sergelogvinov/proxmox-cloud-controller-manager/pkg/cluster"
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/volume"
 
 	corev1 "k8s.io/api/core/v1"
@@ -38,6 +44,9 @@ import (
 )
 
 const (
+	// DefaultOperationTimeout is the default timeout of the controller requests
+	DefaultOperationTimeout = 3 * time.Minute
+
 	vmID = 9999
 
 	deviceNamePrefix = "scsi"
@@ -49,14 +58,63 @@ var controllerCaps = []csi.ControllerServiceCapability_RPC_Type{
 	csi.ControllerServiceCapability_RPC_GET_CAPACITY,
 	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 	csi.ControllerServiceCapability_RPC_GET_VOLUME,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
 	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
 }
 
+// diskQoSOptions maps the StorageClass parameters to the Proxmox disk throttling options
+var diskQoSOptions = map[string]string{
+	StorageDiskIOPSKey:      "iops",
+	StorageDiskReadIOPSKey:  "iops_rd",
+	StorageDiskWriteIOPSKey: "iops_wr",
+	StorageDiskMBpsKey:      "mbps",
+	StorageDiskReadMBpsKey:  "mbps_rd",
+	StorageDiskWriteMBpsKey: "mbps_wr",
+
+	StorageDiskIOPSBurstKey:      "iops_max",
+	StorageDiskReadIOPSBurstKey:  "iops_rd_max",
+	StorageDiskWriteIOPSBurstKey: "iops_wr_max",
+	StorageDiskMBpsBurstKey:      "mbps_max",
+	StorageDiskReadMBpsBurstKey:  "mbps_rd_max",
+	StorageDiskWriteMBpsBurstKey: "mbps_wr_max",
+
+	StorageDiskIOPSBurstLengthKey:      "iops_max_length",
+	StorageDiskReadIOPSBurstLengthKey:  "iops_rd_max_length",
+	StorageDiskWriteIOPSBurstLengthKey: "iops_wr_max_length",
+	StorageDiskMBpsBurstLengthKey:      "bps_max_length",
+	StorageDiskReadMBpsBurstLengthKey:  "bps_rd_max_length",
+	StorageDiskWriteMBpsBurstLengthKey: "bps_wr_max_length",
+}
+
 // ControllerService is the controller service for the CSI driver
 type ControllerService struct {
 	Cluster *proxmox.Cluster
-
-	volumeLocks sync.Mutex
+	// Trash enables the soft-delete of volumes, the deleted volumes are purged by PurgeTrash
+	Trash VolumeTrash
+
+	// OperationTimeout limits the time of the Proxmox API calls of one request, DefaultOperationTimeout if zero
+	OperationTimeout time.Duration
+	// CacheTTL is the lifetime of the cached node lists, storage configs and storage content, zero disables the cache
+	CacheTTL time.Duration
+
+	// volumeLocks serializes the attachments, resizingVolumes are the volids of the detached volumes being resized
+	volumeLocks     sync.Mutex
+	resizingVolumes map[string]bool
+	// vmIDLock serializes the creation of the temporary VMs, the next VM ID is not reserved until the VM is created
+	vmIDLock sync.Mutex
+
+	volumeIndexLock sync.Mutex
+	volumeIndexes   map[string]*volumeIndex
+
+	apiCacheLock sync.Mutex
+	apiCaches    map[string]*apiCache
+
+	// cloudConfig is the path of the cloud config, config is the applied config and reloadErr is the error of the last reload
+	configLock  sync.Mutex
+	cloudConfig string
+	config      proxmox.ClustersConfig
+	reloadErr   error
 }
 
 // NewControllerService returns a new controller service
@@ -72,14 +130,26 @@ func NewControllerService(cloudConfig string) (*ControllerService, error) {
 	}
 
 	return &ControllerService{
-		Cluster: cluster,
+		Cluster:     cluster,
+		cloudConfig: cloudConfig,
+		config:      cfg,
 	}, nil
 }
 
+// withTimeout returns the context of the request limited by OperationTimeout, the earlier deadline of the request is kept.
+func (d *ControllerService) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := d.OperationTimeout
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
 // CreateVolume creates a volume
 //
 //nolint:gocyclo,cyclop
-func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+func (d *ControllerService) CreateVolume(ctx context.Context, request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	klog.V(4).Infof("CreateVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	pvc := request.GetName()
@@ -97,22 +167,21 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 		return nil, status.Error(codes.InvalidArgument, "Parameters must be provided")
 	}
 
-	if params[StorageIDKey] == "" {
+	if params[StorageIDKey] == "" && params[StorageSelectorKey] == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be provided", StorageIDKey)
 	}
 
-	if params[StorageBlockSizeKey] != "" {
-		if _, err := strconv.Atoi(params[StorageBlockSizeKey]); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be a number", StorageBlockSizeKey)
-		}
+	if len(request.GetMutableParameters()) > 0 {
+		return nil, status.Error(codes.InvalidArgument, "VolumeAttributesClass is not supported, the disk options are set by StorageClass parameters")
 	}
 
-	if params[StorageInodeSizeKey] != "" {
-		if _, err := strconv.Atoi(params[StorageInodeSizeKey]); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be a number", StorageInodeSizeKey)
-		}
+	storageParams, err := parseStorageParameters(params, true)
+	if err != nil {
+		return nil, err
 	}
 
+	volName := getVolumeName(pvc, params)
+
 	// Volume Size - Default is 10 GiB
 	volSizeBytes := int64(DefaultVolumeSize * 1024 * 1024 * 1024)
 	if request.GetCapacityRange() != nil {
@@ -130,18 +199,61 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 		return nil, status.Error(codes.Internal, "cannot find best region")
 	}
 
-	cl, err := d.Cluster.GetProxmoxCluster(region)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, region)
 	if err != nil {
 		klog.Errorf("CreateVolume: failed to get proxmox cluster: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
+	}
+
+	cache := d.getAPICache(region)
+
+	storages, err := getStorageCandidates(ctx, cl, cache, params)
+	if err != nil {
+		klog.Errorf("CreateVolume: failed to get storages: %v", err)
+
+		return nil, statusError(err)
+	}
+
+	if len(storages) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be provided", StorageIDKey)
 	}
 
+	storageName := storages[0]
+
 	if zone == "" {
-		if zone, err = getNodeWithStorage(cl, params[StorageIDKey]); err != nil {
-			klog.Errorf("CreateVolume: failed to get node with storage: %v", err)
+		zones := zonesFromTopologyRequirement(accessibleTopology, region)
+
+		existingStorage, existingZone, err := findVolumeNode(ctx, cl, cache, region, storages, zones, volName, storageParams.Format)
+		if err != nil {
+			klog.Errorf("CreateVolume: failed to find existing volume: %v", err)
+
+			return nil, statusError(err)
+		}
+
+		if existingZone != "" {
+			storageName, zone = existingStorage, existingZone
+		} else {
+			for _, storageName = range storages {
+				if zone, err = getNodeWithStorage(ctx, cl, cache, storageName, zones, storageParams.ZoneWeights, volSizeBytes); err == nil {
+					break
+				}
+			}
+
+			if err != nil {
+				klog.Errorf("CreateVolume: failed to get node with storage: %v", err)
 
-			return nil, status.Errorf(codes.Internal, "cannot find best region and zone: %v", err)
+				return nil, status.Errorf(errorCode(err), "cannot find best region and zone: %v", err)
+			}
+		}
+	} else if len(storages) > 1 {
+		if storageName, err = getStorageOnNode(ctx, cl, cache, region, zone, storages, volName, storageParams.Format, volSizeBytes); err != nil {
+			klog.Errorf("CreateVolume: failed to get storage on node %s: %v", zone, err)
+
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
 		}
 	}
 
@@ -151,11 +263,11 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 		return nil, status.Error(codes.Internal, "cannot find best region and zone")
 	}
 
-	storageConfig, err := cl.GetStorageConfig(params[StorageIDKey])
+	storageConfig, err := cache.storageConfig(ctx, cl, storageName)
 	if err != nil {
 		klog.Errorf("CreateVolume: failed to get proxmox storage config: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	klog.V(4).Infof("CreateVolume: storage config: %+v", storageConfig)
@@ -167,11 +279,41 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 		},
 	}
 
+	if storageParams.Shared && (storageConfig["shared"] == nil || int(storageConfig["shared"].(float64)) != 1) {
+		return nil, status.Errorf(codes.InvalidArgument, "storage %s is not shared, it cannot be attached to many nodes", storageName)
+	}
+
+	storageType, _ := storageConfig["type"].(string) //nolint:errcheck
+	networkFS := isNetworkStorage(storageType)
+
+	if !isDiskFormatSupported(storageConfig, storageParams.Format) {
+		return nil, status.Errorf(codes.InvalidArgument, "disk format %s is not supported on storage %s", storageParams.Format, storageName)
+	}
+
+	for _, c := range volCapabilities {
+		if networkFS {
+			if c.GetBlock() != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "raw block volumes are not supported on %s storage", storageType)
+			}
+
+			continue
+		}
+
+		if isMultiNodeVolumeCapability(c) {
+			if !storageParams.Shared {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be true for multi-node access mode", StorageSharedKey)
+			}
+
+			if c.GetBlock() == nil {
+				return nil, status.Error(codes.InvalidArgument, "multi-node access mode is supported only for raw block volumes")
+			}
+		}
+	}
+
 	if storageConfig["shared"] != nil && int(storageConfig["shared"].(float64)) == 1 {
-		// https://pve.proxmox.com/wiki/Storage only block/local storage are supported
-		switch storageConfig["type"].(string) {
-		case "nfs", "cifs", "pbs":
-			return nil, status.Error(codes.Internal, "error: shared storage type nfs,cifs,pbs are not supported")
+		// https://pve.proxmox.com/wiki/Storage only block/local and nfs/cifs storage are supported
+		if storageType == "pbs" {
+			return nil, status.Error(codes.Internal, "error: shared storage type pbs is not supported")
 		}
 
 		topology = &csi.Topology{
@@ -181,33 +323,55 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 		}
 	}
 
-	vol := volume.NewVolume(region, zone, params[StorageIDKey], fmt.Sprintf("vm-%d-%s", vmID, pvc))
-	if storageConfig["path"] != nil && storageConfig["path"].(string) != "" {
-		vol = volume.NewVolume(region, zone, params[StorageIDKey], fmt.Sprintf("%d/vm-%d-%s.raw", vmID, vmID, pvc))
+	vol := volume.NewVolume(region, zone, storageName, getVolumeDiskName(storageConfig, volName, storageParams.Format))
+
+	volCtx := params
+
+	if networkFS {
+		volCtx, err = networkVolumeContext(storageConfig, vol, params)
+		if err != nil {
+			klog.Errorf("CreateVolume: failed to get mount source: %v", err)
+
+			return nil, statusError(err)
+		}
 	}
 
 	// Check if volume already exists, and use it if it has the same size, otherwise create a new one
-	size, err := getVolumeSize(cl, vol)
+	size, err := getVolumeSize(ctx, cl, cache, vol)
 	if err != nil {
-		if err.Error() != ErrorNotFound {
+		if !errors.Is(err, proxmox.ErrNotFound) {
 			klog.Errorf("CreateVolume: failed to check if pvc exists: %v", err)
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
 		}
 
-		err = createVolume(cl, vol, volSizeGB)
+		err = createVolume(ctx, cl, cache, vol, volSizeGB)
 		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
 		}
-	} else if size != int64(volSizeGB*1024*1024*1024) {
+	} else if !networkFS && size != int64(volSizeGB*1024*1024*1024) {
+		// Directories on network storage do not have quotas, so the size is not checked
 		klog.Errorf("CreateVolume: volume %s is already exists, volume size %d, expected %d", vol.VolumeID(), size, int64(volSizeGB*1024*1024*1024))
 
 		return nil, status.Error(codes.AlreadyExists, "volume already exists with same name and different capacity")
+	} else if d.Trash != nil {
+		// The existing volume is used again, it must be detached from the trash VM and must not be purged
+		if err = d.untrashVolume(ctx, cl, vol); err != nil {
+			klog.Errorf("CreateVolume: failed to detach volume %s from the trash vm: %v", vol.VolumeID(), err)
+
+			return nil, statusError(err)
+		}
+
+		if err = d.Trash.Remove(ctx, vol.VolumeID()); err != nil {
+			klog.Errorf("CreateVolume: failed to remove volume %s from the trash: %v", vol.VolumeID(), err)
+
+			return nil, statusError(err)
+		}
 	}
 
 	volume := csi.Volume{
 		VolumeId:      vol.VolumeID(),
-		VolumeContext: params,
+		VolumeContext: volCtx,
 		ContentSource: request.GetVolumeContentSource(),
 		CapacityBytes: int64(volSizeGB * 1024 * 1024 * 1024),
 		AccessibleTopology: []*csi.Topology{
@@ -219,7 +383,7 @@ func (d *ControllerService) CreateVolume(_ context.Context, request *csi.CreateV
 }
 
 // DeleteVolume deletes a volume.
-func (d *ControllerService) DeleteVolume(_ context.Context, request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+func (d *ControllerService) DeleteVolume(ctx context.Context, request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	klog.V(4).Infof("DeleteVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	volumeID := request.GetVolumeId()
@@ -232,18 +396,23 @@ func (d *ControllerService) DeleteVolume(_ context.Context, request *csi.DeleteV
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	cl, err := d.Cluster.GetProxmoxCluster(vol.Cluster())
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
 	if err != nil {
 		klog.Errorf("failed to get proxmox cluster: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
-	exist, err := isPvcExists(cl, vol)
+	cache := d.getAPICache(vol.Cluster())
+
+	exist, err := isPvcExists(ctx, cl, cache, vol)
 	if err != nil {
 		klog.Errorf("failed to verify the existence of the PVC: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	if !exist {
@@ -252,14 +421,30 @@ func (d *ControllerService) DeleteVolume(_ context.Context, request *csi.DeleteV
 		return &csi.DeleteVolumeResponse{}, nil
 	}
 
-	vmr := pxapi.NewVmRef(vmID)
-	vmr.SetNode(vol.Node())
-	vmr.SetVmType("qemu")
+	if d.Trash != nil {
+		deletedAt := time.Now()
+
+		if err := d.trashVolume(ctx, cl, vol, deletedAt); err != nil {
+			klog.Errorf("failed to move volume %s to the trash vm: %v", volumeID, err)
+
+			return nil, statusError(err)
+		}
+
+		if err := d.Trash.Add(ctx, volumeID, deletedAt); err != nil {
+			klog.Errorf("failed to move volume %s to the trash: %v", volumeID, err)
+
+			return nil, statusError(err)
+		}
+
+		klog.V(3).Infof("DeleteVolume: volume %s is moved to the trash", volumeID)
 
-	if _, err := cl.DeleteVolume(vmr, vol.Storage(), vol.Disk()); err != nil {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := deleteVolume(ctx, cl, cache, vol); err != nil {
 		klog.Errorf("failed to delete volume: %s", vol.Disk())
 
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to delete volume: %s", vol.Disk()))
+		return nil, statusError(err)
 	}
 
 	klog.V(4).Infof("DeleteVolume: successfully deleted volume %s", vol.Disk())
@@ -288,7 +473,7 @@ func (d *ControllerService) ControllerGetCapabilities(_ context.Context, request
 }
 
 // ControllerPublishVolume publish a volume
-func (d *ControllerService) ControllerPublishVolume(_ context.Context, request *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+func (d *ControllerService) ControllerPublishVolume(ctx context.Context, request *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	klog.V(4).Infof("ControllerPublishVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	volumeID := request.GetVolumeId()
@@ -315,69 +500,71 @@ func (d *ControllerService) ControllerPublishVolume(_ context.Context, request *
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	cl, err := d.Cluster.GetProxmoxCluster(vol.Cluster())
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
 	if err != nil {
 		klog.Errorf("failed to get proxmox cluster: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	vm, err := cl.GetVmRefByName(nodeID)
 	if err != nil {
 		klog.Errorf("failed to get vm ref by name: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
-	// if vm.Node() != vol.Node() {
-	// 	return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume %s does not exist on the node %s", volumeID, nodeID))
-	// }
+	if volCtx[MountSourceKey] != "" {
+		exist, err := isPvcExists(ctx, cl, d.getAPICache(vol.Cluster()), vol) //nolint:govet
+		if err != nil {
+			klog.Errorf("failed to verify the existence of the volume: %v", err)
 
-	options := map[string]string{
-		"backup":   "0",
-		"iothread": "1",
-	}
+			return nil, statusError(err)
+		}
 
-	if request.Readonly {
-		options["ro"] = "1"
-	}
+		if !exist {
+			return nil, status.Error(codes.NotFound, "failed to find volume")
+		}
 
-	if volCtx[StorageSSDKey] == "true" {
-		options["ssd"] = "1"
-		options["discard"] = "on"
+		// Network volumes are mounted by the node, nothing to attach to the VM
+		return &csi.ControllerPublishVolumeResponse{}, nil
 	}
 
-	if volCtx[StorageCacheKey] != "" {
-		options["cache"] = volCtx[StorageCacheKey]
+	if isMultiNodeVolumeCapability(request.GetVolumeCapability()) {
+		if volCtx[StorageSharedKey] != "true" {
+			return nil, status.Error(codes.InvalidArgument, "multi-node access mode is supported only for shared volumes")
+		}
+
+		if request.GetVolumeCapability().GetBlock() == nil {
+			return nil, status.Error(codes.InvalidArgument, "multi-node access mode is supported only for raw block volumes")
+		}
 	}
 
-	if volCtx[StorageDiskIOPSKey] != "" {
-		iops, err := strconv.Atoi(volCtx[StorageDiskIOPSKey]) //nolint:govet
-		if err != nil {
-			klog.Errorf("failed %s must be a number: %v", StorageDiskIOPSKey, err)
+	// if vm.Node() != vol.Node() {
+	// 	return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume %s does not exist on the node %s", volumeID, nodeID))
+	// }
 
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed %s must be a number: %v", StorageDiskIOPSKey, err))
-		}
+	storageParams, err := parseStorageParameters(volCtx, false)
+	if err != nil {
+		klog.Errorf("ControllerPublishVolume: invalid volume context: %v", err)
 
-		options["iops"] = strconv.Itoa(iops)
+		return nil, err
 	}
 
-	if volCtx[StorageDiskMBpsKey] != "" {
-		mbps, err := strconv.Atoi(volCtx[StorageDiskMBpsKey]) //nolint:govet
-		if err != nil {
-			klog.Errorf("failed %s must be a number: %v", StorageDiskMBpsKey, err)
-
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed %s must be a number: %v", StorageDiskMBpsKey, err))
-		}
+	options := storageParams.diskOptions()
 
-		options["mbps"] = strconv.Itoa(mbps)
+	if request.Readonly {
+		options["ro"] = "1"
 	}
 
-	exist, err := isPvcExists(cl, vol)
+	exist, err := isPvcExists(ctx, cl, d.getAPICache(vol.Cluster()), vol)
 	if err != nil {
 		klog.Errorf("failed to verify the existence of the volume: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	if !exist {
@@ -387,18 +574,24 @@ func (d *ControllerService) ControllerPublishVolume(_ context.Context, request *
 	d.volumeLocks.Lock()
 	defer d.volumeLocks.Unlock()
 
-	pvInfo, err := attachVolume(cl, vm, vol.Storage(), vol.Disk(), options)
+	if d.resizingVolumes[getVolid(vol)] {
+		return nil, statusError(fmt.Errorf("%s: %w", vol.Disk(), errVolumeResizing))
+	}
+
+	pvInfo, err := attachVolume(ctx, cl, vm, vol.Storage(), vol.Disk(), options)
+	d.getVolumeIndex(vol.Cluster()).invalidate(vm.VmId())
+
 	if err != nil {
 		klog.Errorf("failed to attach volume: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
 	return &csi.ControllerPublishVolumeResponse{PublishContext: pvInfo}, nil
 }
 
 // ControllerUnpublishVolume unpublish a volume
-func (d *ControllerService) ControllerUnpublishVolume(_ context.Context, request *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+func (d *ControllerService) ControllerUnpublishVolume(ctx context.Context, request *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	klog.V(4).Infof("ControllerUnpublishVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	volumeID := request.GetVolumeId()
@@ -416,24 +609,42 @@ func (d *ControllerService) ControllerUnpublishVolume(_ context.Context, request
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	cl, err := d.Cluster.GetProxmoxCluster(vol.Cluster())
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
 	if err != nil {
 		klog.Errorf("failed to get proxmox cluster: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
-	vm, err := cl.GetVmRefByName(nodeID)
+	idx := d.getVolumeIndex(vol.Cluster())
+
+	attachments, err := d.lookupVolume(ctx, cl, vol, nodeID)
 	if err != nil {
-		klog.Errorf("failed to get vm ref by name: %v", err)
+		klog.Errorf("failed to find the volume attachments: %v", err)
+
+		return nil, statusError(err)
+	}
+
+	if len(attachments) == 0 {
+		if _, err := cl.GetVmRefByName(nodeID); err != nil {
+			klog.Errorf("failed to get vm ref by name: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
+		}
 	}
 
-	if err := detachVolume(cl, vm, vol.Disk()); err != nil {
-		klog.Errorf("failed to detachVolume: %v", err)
+	for _, attachment := range attachments {
+		err := detachVolume(ctx, cl, attachment.VM, vol.Disk())
+		idx.invalidate(attachment.VM.VmId())
+
+		if err != nil {
+			klog.Errorf("failed to detachVolume: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
+		}
 	}
 
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
@@ -447,61 +658,347 @@ func (d *ControllerService) ValidateVolumeCapabilities(_ context.Context, reques
 }
 
 // ListVolumes list volumes
-func (d *ControllerService) ListVolumes(_ context.Context, request *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+func (d *ControllerService) ListVolumes(ctx context.Context, request *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
 	klog.V(4).Infof("ListVolumes: called with args %+v", protosanitizer.StripSecrets(*request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	start := 0
+	if token := request.GetStartingToken(); token != "" {
+		var err error
+
+		if start, err = strconv.Atoi(token); err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted, "invalid starting token %s", token)
+		}
+	}
+
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	volumes := []*csi.Volume{}
+	published := map[string][]string{}
+
+	for _, region := range d.Cluster.Regions() {
+		cl, err := d.Cluster.GetProxmoxCluster(ctx, region)
+		if err != nil {
+			klog.Errorf("failed to get proxmox cluster: %v", err)
+
+			return nil, statusError(err)
+		}
+
+		vols, err := listRegionVolumes(ctx, cl, region)
+		if err != nil {
+			klog.Errorf("ListVolumes: failed to list volumes in region %s: %v", region, err)
+
+			return nil, statusError(err)
+		}
+
+		attachments, err := d.getVolumeIndex(region).attachments(ctx, cl)
+		if err != nil {
+			klog.Errorf("ListVolumes: failed to list volume attachments in region %s: %v", region, err)
+
+			return nil, statusError(err)
+		}
+
+		// The attachments are matched by the volid, so the zone of the shared volume does not matter
+		for _, vol := range vols {
+			published[vol.VolumeId] = append(published[vol.VolumeId], attachments[getVolidFromVolumeID(vol.VolumeId)]...)
+		}
+
+		volumes = append(volumes, vols...)
+	}
+
+	if d.Trash != nil {
+		trash, err := d.Trash.List(ctx)
+		if err != nil {
+			klog.Errorf("ListVolumes: failed to list the trash: %v", err)
+
+			return nil, statusError(err)
+		}
+
+		trashed := map[string]bool{}
+		for volumeID := range trash {
+			trashed[volumeID] = true
+			trashed[sharedVolumeKey(volumeID)] = true
+		}
+
+		volumes = slices.DeleteFunc(volumes, func(vol *csi.Volume) bool {
+			if isSharedVolume(vol) {
+				return trashed[sharedVolumeKey(vol.VolumeId)]
+			}
+
+			return trashed[vol.VolumeId]
+		})
+	}
+
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].VolumeId < volumes[j].VolumeId })
+
+	if start > len(volumes) {
+		return nil, status.Errorf(codes.Aborted, "invalid starting token %d", start)
+	}
+
+	end := len(volumes)
+	if maxEntries := int(request.GetMaxEntries()); maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+	}
+
+	response := &csi.ListVolumesResponse{
+		Entries: make([]*csi.ListVolumesResponse_Entry, 0, end-start),
+	}
+
+	for _, vol := range volumes[start:end] {
+		response.Entries = append(response.Entries, &csi.ListVolumesResponse_Entry{
+			Volume: vol,
+			Status: &csi.ListVolumesResponse_VolumeStatus{PublishedNodeIds: published[vol.VolumeId]},
+		})
+	}
+
+	if end < len(volumes) {
+		response.NextToken = strconv.Itoa(end)
+	}
+
+	return response, nil
+}
+
+// listRegionVolumes returns the volumes of the plugin on all storages of the region.
+// The PVC metadata of the volume is returned in the volume context.
+func listRegionVolumes(ctx context.Context, cl *pxapi.Client, region string) ([]*csi.Volume, error) {
+	storages, err := cl.GetResourceList("storage")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage list: %v", err)
+	}
+
+	volumes := []*csi.Volume{}
+	sharedStorages := map[string]bool{}
+
+	for _, item := range storages {
+		storage, ok := item.(map[string]interface{})
+		if !ok || storage["type"] != "storage" || storage["status"] != "available" {
+			continue
+		}
+
+		node, _ := storage["node"].(string)           //nolint:errcheck
+		storageName, _ := storage["storage"].(string) //nolint:errcheck
+		content, _ := storage["content"].(string)     //nolint:errcheck
+
+		if !slices.Contains(strings.Split(content, ","), "images") && !slices.Contains(strings.Split(content, ","), "rootdir") {
+			continue
+		}
+
+		shared := false
+		if v, ok := storage["shared"].(float64); ok && int(v) == 1 {
+			// Shared storage has the same content on all nodes
+			if sharedStorages[storageName] {
+				continue
+			}
+
+			shared = true
+			sharedStorages[storageName] = true
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		vmr := pxapi.NewVmRef(vmID)
+		vmr.SetNode(node)
+		vmr.SetVmType("qemu")
+
+		data, err := cl.GetStorageContent(vmr, storageName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get storage %s content on node %s: %v", storageName, node, err)
+		}
+
+		images, _ := data["data"].([]interface{}) //nolint:errcheck
+		for _, i := range images {
+			image, ok := i.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			volid, _ := image["volid"].(string) //nolint:errcheck
+			size, _ := image["size"].(float64)  //nolint:errcheck
+			vol := volume.NewVolume(region, node, storageName, strings.TrimPrefix(volid, storageName+":"))
+
+			if !strings.HasPrefix(path.Base(vol.Disk()), fmt.Sprintf("vm-%d-", vmID)) {
+				continue
+			}
+
+			segments := map[string]string{
+				corev1.LabelTopologyRegion: region,
+				corev1.LabelTopologyZone:   node,
+			}
+			if shared {
+				delete(segments, corev1.LabelTopologyZone)
+			}
+
+			// The metadata is not reported if the disk name has no metadata or it cannot be parsed
+			metadata, _ := getVolumeMetadata(vol.Disk()) //nolint:errcheck
+
+			volumes = append(volumes, &csi.Volume{
+				VolumeId:           vol.VolumeID(),
+				CapacityBytes:      int64(size),
+				VolumeContext:      metadata,
+				AccessibleTopology: []*csi.Topology{{Segments: segments}},
+			})
+		}
+	}
+
+	return volumes, nil
+}
+
+// isSharedVolume returns true if the listed volume is on a shared storage, it has no zone in the topology.
+func isSharedVolume(vol *csi.Volume) bool {
+	for _, topology := range vol.AccessibleTopology {
+		if topology.Segments[corev1.LabelTopologyZone] != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sharedVolumeKey returns the region and the volid of the volume.
+// The volume ID of the shared volume has the node it was created on, and the listed volume has the first node with the storage,
+// so the shared volumes are matched by the key without the zone.
+func sharedVolumeKey(volumeID string) string {
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return volumeID
+	}
+
+	return vol.Region() + "/" + getVolid(vol)
 }
 
 // GetCapacity get capacity
-func (d *ControllerService) GetCapacity(_ context.Context, request *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+func (d *ControllerService) GetCapacity(ctx context.Context, request *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
 	klog.V(4).Infof("GetCapacity: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	topology := request.GetAccessibleTopology()
 	if topology != nil {
+		params := request.GetParameters()
 		region := topology.Segments[corev1.LabelTopologyRegion]
 		zone := topology.Segments[corev1.LabelTopologyZone]
-		storageName := request.GetParameters()[StorageIDKey]
 
-		if region == "" || zone == "" || storageName == "" {
-			return nil, status.Error(codes.InvalidArgument, "region, zone and storageName must be provided")
+		if region == "" || (params[StorageIDKey] == "" && params[StorageSelectorKey] == "") {
+			return nil, status.Error(codes.InvalidArgument, "region and storageName must be provided")
+		}
+
+		overcommit, err := parseOvercommitRatio(params[StorageOvercommitRatioKey])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s: %v", StorageOvercommitRatioKey, err)
 		}
 
-		klog.V(4).Infof("GetCapacity: region=%s, zone=%s, storageName=%s", region, zone, storageName)
+		klog.V(4).Infof("GetCapacity: region=%s, zone=%s, storage=%s, storageSelector=%s", region, zone, params[StorageIDKey], params[StorageSelectorKey])
 
-		cl, err := d.Cluster.GetProxmoxCluster(region)
+		ctx, cancel := d.withTimeout(ctx)
+		defer cancel()
+
+		cl, err := d.Cluster.GetProxmoxCluster(ctx, region)
 		if err != nil {
 			klog.Errorf("failed to get proxmox cluster: %v", err)
 
-			return nil, status.Error(codes.Internal, err.Error())
+			return nil, statusError(err)
 		}
 
-		vmr := pxapi.NewVmRef(vmID)
-		vmr.SetNode(zone)
-		vmr.SetVmType("qemu")
+		cache := d.getAPICache(region)
 
-		availableCapacity := int64(0)
-
-		storage, err := cl.GetStorageStatus(vmr, storageName)
+		storages, err := getStorageCandidates(ctx, cl, cache, params)
 		if err != nil {
-			klog.Errorf("GetCapacity: failed to get storage status: %v", err)
+			klog.Errorf("GetCapacity: failed to get storages: %v", err)
+
+			return nil, statusError(err)
+		}
+
+		response := &csi.GetCapacityResponse{}
 
-			if !strings.Contains(err.Error(), "Parameter verification failed") {
-				return nil, status.Error(codes.Internal, err.Error())
+		// The capacity of many storages is the sum of them, the maximum volume size is the largest one
+		for _, storageName := range storages {
+			capacity, err := getStorageCapacity(ctx, cl, cache, zone, storageName, overcommit, len(storages) > 1) //nolint:govet
+			if err != nil {
+				return nil, err
 			}
-		} else {
-			availableCapacity = int64(storage["avail"].(float64))
+
+			if capacity == nil {
+				continue
+			}
+
+			response.AvailableCapacity += capacity.AvailableCapacity
+			if response.MaximumVolumeSize == nil || response.MaximumVolumeSize.Value < capacity.MaximumVolumeSize.Value {
+				response.MaximumVolumeSize = capacity.MaximumVolumeSize
+			}
+
+			response.MinimumVolumeSize = capacity.MinimumVolumeSize
 		}
 
-		return &csi.GetCapacityResponse{
-			// MinimumVolumeSize: MinVolumeSize * 1024 * 1024 * 1024,
-			AvailableCapacity: availableCapacity,
-		}, nil
+		return response, nil
 	}
 
 	return nil, status.Error(codes.InvalidArgument, "no topology specified")
 }
 
+// getStorageCapacity returns the capacity of the storage on the zone,
+// or nil if the storage is not available there.
+// If the zone is empty, the storage must be shared, non-shared storages are skipped when skipLocal is set.
+func getStorageCapacity(ctx context.Context, cl *pxapi.Client, cache *apiCache, zone, storageName string, overcommit float64, skipLocal bool) (*csi.GetCapacityResponse, error) {
+	if zone == "" {
+		storageConfig, err := cache.storageConfig(ctx, cl, storageName)
+		if err != nil {
+			klog.Errorf("GetCapacity: failed to get proxmox storage config: %v", err)
+
+			return nil, statusError(err)
+		}
+
+		if storageConfig["shared"] == nil || int(storageConfig["shared"].(float64)) != 1 {
+			if skipLocal {
+				return nil, nil
+			}
+
+			return nil, status.Error(codes.InvalidArgument, "zone must be provided for non-shared storage")
+		}
+
+		// Shared storage has the same capacity on all nodes, so any node with the storage can be used
+		if zone, err = getNodeWithStorage(ctx, cl, cache, storageName, nil, nil, 0); err != nil {
+			klog.Errorf("GetCapacity: failed to get node with storage: %v", err)
+
+			return nil, nil
+		}
+	}
+
+	vmr := pxapi.NewVmRef(vmID)
+	vmr.SetNode(zone)
+	vmr.SetVmType("qemu")
+
+	storage, err := cl.GetStorageStatus(vmr, storageName)
+	if err != nil {
+		klog.Errorf("GetCapacity: failed to get storage status: %v", err)
+
+		if !errors.Is(proxmox.ParseError(err), proxmox.ErrInvalidParameter) {
+			return nil, statusError(err)
+		}
+
+		return nil, nil
+	}
+
+	availableCapacity := int64(0)
+	if avail, ok := storage["avail"].(float64); ok {
+		availableCapacity = int64(avail)
+	}
+
+	if storageType, _ := storage["type"].(string); isThinStorage(storageType) { //nolint:errcheck
+		availableCapacity = int64(float64(availableCapacity) * overcommit)
+	}
+
+	maximumVolumeSize := availableCapacity
+	if total, ok := storage["total"].(float64); ok && int64(total) > 0 && int64(total) < maximumVolumeSize {
+		maximumVolumeSize = int64(total)
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: availableCapacity,
+		MaximumVolumeSize: &wrappers.Int64Value{Value: maximumVolumeSize},
+		MinimumVolumeSize: &wrappers.Int64Value{Value: MinVolumeSize * 1024 * 1024 * 1024},
+	}, nil
+}
+
 // CreateSnapshot create a snapshot
 func (d *ControllerService) CreateSnapshot(_ context.Context, request *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
 	klog.V(4).Infof("CreateSnapshot: called with args %+v", protosanitizer.StripSecrets(*request))
@@ -524,7 +1021,7 @@ func (d *ControllerService) ListSnapshots(_ context.Context, request *csi.ListSn
 }
 
 // ControllerExpandVolume expand a volume
-func (d *ControllerService) ControllerExpandVolume(_ context.Context, request *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+func (d *ControllerService) ControllerExpandVolume(ctx context.Context, request *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	klog.V(4).Infof("ControllerExpandVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
 	volumeID := request.GetVolumeId()
@@ -557,18 +1054,23 @@ func (d *ControllerService) ControllerExpandVolume(_ context.Context, request *c
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	cl, err := d.Cluster.GetProxmoxCluster(vol.Cluster())
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
 	if err != nil {
 		klog.Errorf("failed to get proxmox cluster: %v", err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
-	exist, err := isPvcExists(cl, vol)
+	cache := d.getAPICache(vol.Cluster())
+
+	exist, err := isPvcExists(ctx, cl, cache, vol)
 	if err != nil {
 		klog.Errorf("failed to check if pvc exists: %v", err)
 
-		return nil, status.Error(codes.NotFound, err.Error())
+		return nil, statusError(err)
 	}
 
 	if !exist {
@@ -577,81 +1079,156 @@ func (d *ControllerService) ControllerExpandVolume(_ context.Context, request *c
 		return &csi.ControllerExpandVolumeResponse{}, nil
 	}
 
-	vmlist, err := cl.GetVmList()
+	if isNetworkVolume(vol) {
+		// Directories on network storage do not have quotas, nothing to resize
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         volSizeBytes,
+			NodeExpansionRequired: false,
+		}, nil
+	}
+
+	// The size in the storage content is changed by the resize
+	defer cache.invalidateContent(vol.Storage())
+
+	attached, err := d.resizeAttachedVolume(ctx, cl, vol, fmt.Sprintf("%dG", volSizeGB))
 	if err != nil {
-		klog.Errorf("failed to get vm list: %v", err)
+		klog.Errorf("failed to resize vm disk: %s, %v", vol.Disk(), err)
 
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(err)
 	}
 
-	vms, ok := vmlist["data"].([]interface{})
-	if !ok {
-		err = fmt.Errorf("failed to cast response to list, vmlist: %v", vmlist)
-
-		return nil, status.Error(codes.Internal, err.Error())
+	if attached {
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         volSizeBytes,
+			NodeExpansionRequired: true,
+		}, nil
 	}
 
-	for vmii := range vms {
-		vm, ok := vms[vmii].(map[string]interface{})
-		if !ok {
-			return nil, status.Errorf(codes.Internal, "failed to cast response to map, vm: %v", vm)
-		}
+	klog.V(4).Infof("ControllerExpandVolume: volume %s is not attached, resizing it offline", volumeID)
 
-		if vm["type"].(string) != "qemu" {
-			klog.V(4).Infof("ControllerExpandVolume: skipping non-qemu VM %s", vm["name"].(string))
+	// The volume must not be attached while it is resized offline, ControllerPublishVolume is aborted until the resize is done
+	defer func() {
+		d.volumeLocks.Lock()
+		delete(d.resizingVolumes, getVolid(vol))
+		d.volumeLocks.Unlock()
+	}()
 
-			continue
-		}
+	if err := resizeDetachedVolume(ctx, d.Cluster, &d.vmIDLock, vol, fmt.Sprintf("%dG", volSizeGB)); err != nil {
+		klog.Errorf("failed to resize unpublished volume %s: %v", volumeID, err)
 
-		if vm["node"].(string) == vol.Node() {
-			vmID := int(vm["vmid"].(float64))
+		return nil, statusError(err)
+	}
 
-			vmr := pxapi.NewVmRef(vmID)
-			vmr.SetNode(vol.Node())
-			vmr.SetVmType("qemu")
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         volSizeBytes,
+		NodeExpansionRequired: true,
+	}, nil
+}
 
-			config, err := cl.GetVmConfig(vmr)
-			if err != nil {
-				klog.Errorf("failed to get vm config: %v", err)
+// resizeAttachedVolume resizes the volume if it is attached to a VM. Otherwise the volume is marked as being resized,
+// and the caller must resize it offline and remove the mark.
+func (d *ControllerService) resizeAttachedVolume(ctx context.Context, cl *pxapi.Client, vol *volume.Volume, size string) (bool, error) {
+	d.volumeLocks.Lock()
+	defer d.volumeLocks.Unlock()
 
-				return nil, status.Error(codes.Internal, err.Error())
-			}
+	volid := getVolid(vol)
 
-			lun, exist := isVolumeAttached(config, vol.Disk())
-			if !exist {
-				continue
-			}
+	if d.resizingVolumes[volid] {
+		return false, fmt.Errorf("%s: %w", vol.Disk(), errVolumeResizing)
+	}
 
-			device := deviceNamePrefix + strconv.Itoa(lun)
+	attachments, err := d.lookupVolume(ctx, cl, vol, "")
+	if err != nil {
+		return false, fmt.Errorf("failed to find the volume attachments: %v", err)
+	}
 
-			if _, err := cl.ResizeQemuDiskRaw(vmr, device, fmt.Sprintf("%dG", volSizeGB)); err != nil {
-				klog.Errorf("failed to resize vm disk: %s, %v", vol.Disk(), err)
+	if len(attachments) == 0 {
+		if d.resizingVolumes == nil {
+			d.resizingVolumes = map[string]bool{}
+		}
 
-				return nil, status.Error(codes.Internal, err.Error())
-			}
+		d.resizingVolumes[volid] = true
 
-			return &csi.ControllerExpandVolumeResponse{
-				CapacityBytes:         volSizeBytes,
-				NodeExpansionRequired: true,
-			}, nil
-		}
+		return false, nil
 	}
 
-	klog.Errorf("cannot resize unpublished volumeID %s", volumeID)
+	device := deviceNamePrefix + strconv.Itoa(attachments[0].Lun)
 
-	return nil, status.Error(codes.Internal, "cannot resize unpublished volumeID")
+	_, err = cl.ResizeQemuDiskRaw(attachments[0].VM, device, size)
+
+	return true, err
 }
 
 // ControllerGetVolume get a volume
-func (d *ControllerService) ControllerGetVolume(_ context.Context, request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+func (d *ControllerService) ControllerGetVolume(ctx context.Context, request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	klog.V(4).Infof("ControllerGetVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := request.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "VolumeID must be provided")
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
+	if err != nil {
+		klog.Errorf("failed to get proxmox cluster: %v", err)
+
+		return nil, statusError(err)
+	}
+
+	size, err := getVolumeSize(ctx, cl, d.getAPICache(vol.Cluster()), vol)
+	if err != nil {
+		if errors.Is(err, proxmox.ErrNotFound) {
+			return nil, status.Errorf(codes.NotFound, "volume %s not found", volumeID)
+		}
+
+		klog.Errorf("ControllerGetVolume: failed to get volume %s: %v", volumeID, err)
+
+		return nil, statusError(err)
+	}
+
+	attachments, err := d.lookupVolume(ctx, cl, vol, "")
+	if err != nil {
+		klog.Errorf("failed to find the volume attachments: %v", err)
+
+		return nil, statusError(err)
+	}
+
+	nodes := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		if !attachment.Trash {
+			nodes = append(nodes, attachment.Name)
+		}
+	}
+
+	sort.Strings(nodes)
+
+	metadata, _ := getVolumeMetadata(vol.Disk()) //nolint:errcheck
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: size,
+			VolumeContext: metadata,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: nodes,
+		},
+	}, nil
 }
 
-// ControllerModifyVolume modify a volume
+// ControllerModifyVolume modify a volume.
+// The disk options are the options of the VM disk, not of the volume, and they are set again from the StorageClass parameters
+// in the volume context on every publish, so the modified options would be lost. The MODIFY_VOLUME capability is not reported.
 func (d *ControllerService) ControllerModifyVolume(_ context.Context, request *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
 	klog.V(4).Infof("ControllerModifyVolume: called with args %+v", protosanitizer.StripSecrets(*request))
 
-	return nil, status.Error(codes.Unimplemented, "")
+	return nil, status.Error(codes.Unimplemented, "VolumeAttributesClass is not supported")
 }
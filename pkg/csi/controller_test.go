@@ -22,16 +22,18 @@ import (
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	proxmox "github.com/sergelogvinov/proxmox-cloud-controller-manager/pkg/cluster"
 	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -87,6 +89,55 @@ clusters:
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/cluster/resources?type=storage",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{
+					map[string]interface{}{
+						"type":    "storage",
+						"node":    "pve-1",
+						"storage": "local-lvm",
+						"content": "images,rootdir",
+						"status":  "available",
+						"shared":  0,
+					},
+					map[string]interface{}{
+						"type":    "storage",
+						"node":    "pve-1",
+						"storage": "local",
+						"content": "iso,vztmpl",
+						"status":  "available",
+						"shared":  0,
+					},
+					map[string]interface{}{
+						"type":    "storage",
+						"node":    "pve-1",
+						"storage": "rbd",
+						"content": "images",
+						"status":  "available",
+						"shared":  1,
+					},
+					map[string]interface{}{
+						"type":    "storage",
+						"node":    "pve-2",
+						"storage": "rbd",
+						"content": "images",
+						"status":  "available",
+						"shared":  1,
+					},
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/cluster/resources?type=storage",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{},
+			})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/cluster/resources",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{
@@ -104,6 +155,17 @@ clusters:
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/nodes/pve-3/qemu/100/config",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"vmid":  100,
+					"scsi0": "local-lvm:vm-100-disk-0,size=10G",
+				},
+			})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{
@@ -152,12 +214,92 @@ clusters:
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/cluster/nextid",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": "110",
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/config",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/config",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"vmid":  110,
+					"scsi1": "local-lvm:vm-9999-pvc-error,backup=0,size=1G",
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("PUT", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/resize",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	httpmock.RegisterResponder("PUT", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/unlink",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	httpmock.RegisterResponder("DELETE", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/storage/status",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{})
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{
+					map[string]interface{}{
+						"storage": "rbd",
+						"type":    "rbd",
+						"content": "images",
+						"shared":  1,
+					},
+					map[string]interface{}{
+						"storage": "local-lvm",
+						"type":    "lvmthin",
+						"content": "images,rootdir",
+					},
+					map[string]interface{}{
+						"storage": "local",
+						"type":    "dir",
+						"content": "iso,vztmpl",
+					},
+					map[string]interface{}{
+						"storage": "old-lvm",
+						"type":    "lvmthin",
+						"content": "images",
+						"disable": 1,
+					},
+				},
+			})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/local-lvm",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{
@@ -168,12 +310,98 @@ clusters:
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/local",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"shared": 0,
+					"type":   "dir",
+					"path":   "/var/lib/vz",
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local/content",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{
+					map[string]interface{}{
+						"format": "raw",
+						"size":   1024 * 1024 * 1024,
+						"volid":  "local:9999/vm-9999-pvc-qcow2.raw",
+					},
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local/content",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": "local:9999/vm-9999-pvc-qcow2.qcow2",
+			})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/smb",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{
 				"data": map[string]interface{}{
 					"shared": 1,
 					"type":   "cifs",
+					"server": "10.0.0.1",
+					"share":  "data",
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/rbd",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"shared": 1,
+					"type":   "rbd",
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "=~^https://127.0.0.1:8006/api2/json/nodes/pve-[12]/storage/rbd/status$",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"type":   "rbd",
+					"active": 1,
+					"shared": 1,
+					"total":  1000 * 1024 * 1024 * 1024,
+					"used":   800 * 1024 * 1024 * 1024,
+					"avail":  200 * 1024 * 1024 * 1024,
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/rbd/content",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{
+					map[string]interface{}{
+						"format": "raw",
+						"size":   1024 * 1024 * 1024,
+						"volid":  "rbd:vm-9999-pvc-shared",
+					},
+					map[string]interface{}{
+						"format": "raw",
+						"size":   2 * 1024 * 1024 * 1024,
+						"volid":  "rbd:vm-9999-pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10.default.data-postgres-0",
+					},
+					map[string]interface{}{
+						"format": "raw",
+						"size":   10 * 1024 * 1024 * 1024,
+						"volid":  "rbd:vm-100-disk-0",
+					},
 				},
 			})
 		},
@@ -188,6 +416,11 @@ clusters:
 						"size":   1024 * 1024 * 1024,
 						"volid":  "smb:vm-9999-pvc-smb",
 					},
+					map[string]interface{}{
+						"format": "subvol",
+						"size":   0,
+						"volid":  "smb:9999/vm-9999-pvc-smb.subvol",
+					},
 				},
 			})
 		},
@@ -214,6 +447,37 @@ clusters:
 		},
 	)
 
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-2/storage/local-lvm/status",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"type":    "lvmthin",
+					"active":  1,
+					"enabled": 1,
+					"total":   100 * 1024 * 1024 * 1024,
+					"used":    20 * 1024 * 1024 * 1024,
+					"avail":   80 * 1024 * 1024 * 1024,
+				},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-2/storage/local-lvm/content",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": []interface{}{},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-2/storage/local-lvm/content",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": "local-lvm:vm-9999-pvc-zone",
+			})
+		},
+	)
+
 	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/wrong-volume/content",
 		func(req *http.Request) (*http.Response, error) {
 			return httpmock.NewJsonResponse(200, map[string]interface{}{
@@ -273,6 +537,16 @@ clusters:
 		},
 	)
 
+	// Proxmox returns 500 for unknown nodes, storages and VMs
+	httpmock.RegisterNoResponder(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "500 no such resource",
+			StatusCode: http.StatusInternalServerError,
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	})
+
 	cluster, err := proxmox.NewCluster(&cfg, &http.Client{})
 	if err != nil {
 		ts.T().Fatalf("failed to create proxmox cluster client: %v", err)
@@ -313,6 +587,14 @@ func (ts *csiTestSuite) TestCreateVolume() {
 			},
 		},
 	}
+	volcapShared := &proto.VolumeCapability{
+		AccessMode: &proto.VolumeCapability_AccessMode{
+			Mode: proto.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+		AccessType: &proto.VolumeCapability_Block{
+			Block: &proto.VolumeCapability_BlockVolume{},
+		},
+	}
 	volParam := map[string]string{
 		"storage": "local-lvm",
 	}
@@ -348,6 +630,18 @@ func (ts *csiTestSuite) TestCreateVolume() {
 			},
 			expectedError: status.Error(codes.InvalidArgument, "VolumeName must be provided"),
 		},
+		{
+			msg: "MutableParameters",
+			request: &proto.CreateVolumeRequest{
+				Name:                      "volume-id",
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				Parameters:                volParam,
+				MutableParameters:         map[string]string{"diskIOPS": "100"},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeAttributesClass is not supported, the disk options are set by StorageClass parameters"),
+		},
 		{
 			msg: "VolumeCapabilities",
 			request: &proto.CreateVolumeRequest{
@@ -439,49 +733,38 @@ func (ts *csiTestSuite) TestCreateVolume() {
 			expectedError: status.Error(codes.Internal, "cannot find best region and zone: failed to find node with storage fake-storage"),
 		},
 		{
-			msg: "EmptyRegion",
+			msg: "StorageSelectorWithStorage",
 			request: &proto.CreateVolumeRequest{
-				Name:               "volume-id",
-				Parameters:         volParam,
-				VolumeCapabilities: []*proto.VolumeCapability{volcap},
-				CapacityRange:      volsize,
-				AccessibilityRequirements: &proto.TopologyRequirement{
-					Preferred: []*proto.Topology{
-						{
-							Segments: map[string]string{
-								corev1.LabelTopologyZone: "zone",
-							},
-						},
-					},
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":         "local-lvm",
+					"storageSelector": "type=lvmthin",
 				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
 			},
-			expectedError: status.Error(codes.Internal, "cannot find best region"),
+			expectedError: status.Error(codes.InvalidArgument, "Parameters storage and storageSelector are mutually exclusive"),
 		},
 		{
-			msg: "UnknowRegion",
+			msg: "StorageSelectorInvalid",
 			request: &proto.CreateVolumeRequest{
-				Name:               "volume-id",
-				Parameters:         volParam,
-				VolumeCapabilities: []*proto.VolumeCapability{volcap},
-				CapacityRange:      volsize,
-				AccessibilityRequirements: &proto.TopologyRequirement{
-					Preferred: []*proto.Topology{
-						{
-							Segments: map[string]string{
-								corev1.LabelTopologyRegion: "unknown-region",
-							},
-						},
-					},
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storageSelector": "tag=fast",
 				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
 			},
-			expectedError: status.Error(codes.Internal, "proxmox cluster unknown-region not found"),
+			expectedError: status.Error(codes.InvalidArgument, "Parameters storageSelector: selector key \"tag\" is not supported, must be type or content"),
 		},
 		{
-			msg: "NonSupportZonalSMB",
+			msg: "StorageSelector",
 			request: &proto.CreateVolumeRequest{
-				Name: "volume-id",
+				Name: "pvc-shared",
 				Parameters: map[string]string{
-					"storage": "smb",
+					"storageSelector": "type=rbd",
 				},
 				VolumeCapabilities: []*proto.VolumeCapability{volcap},
 				CapacityRange:      volsize,
@@ -490,53 +773,747 @@ func (ts *csiTestSuite) TestCreateVolume() {
 						{
 							Segments: map[string]string{
 								corev1.LabelTopologyRegion: "cluster-1",
-								corev1.LabelTopologyZone:   "pve-1",
 							},
 						},
 					},
 				},
 			},
-			expectedError: status.Error(codes.Internal, "error: shared storage type nfs,cifs,pbs are not supported"),
-		},
-		{
-			msg: "WrongClusterNotFound",
-			request: &proto.CreateVolumeRequest{
-				Name:               "volume-id",
-				Parameters:         volParam,
-				VolumeCapabilities: []*proto.VolumeCapability{volcap},
-				CapacityRange:      volsize,
-				AccessibilityRequirements: &proto.TopologyRequirement{
-					Preferred: []*proto.Topology{
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/rbd/vm-9999-pvc-shared",
+					VolumeContext: map[string]string{
+						"storageSelector": "type=rbd",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
 						{
 							Segments: map[string]string{
-								corev1.LabelTopologyRegion: "fake-region",
-								corev1.LabelTopologyZone:   "zone",
+								corev1.LabelTopologyRegion: "cluster-1",
 							},
 						},
 					},
 				},
 			},
-			expectedError: status.Error(codes.Internal, "proxmox cluster fake-region not found"),
 		},
 		{
-			msg: "WrongCluster",
+			msg: "StorageList",
 			request: &proto.CreateVolumeRequest{
-				Name:               "volume-id",
-				Parameters:         volParam,
+				Name: "pvc-exist-same-size",
+				Parameters: map[string]string{
+					"storage": "rbd,local-lvm",
+				},
 				VolumeCapabilities: []*proto.VolumeCapability{volcap},
 				CapacityRange:      volsize,
 				AccessibilityRequirements: &proto.TopologyRequirement{
 					Preferred: []*proto.Topology{
 						{
 							Segments: map[string]string{
-								corev1.LabelTopologyRegion: "Region-1",
+								corev1.LabelTopologyRegion: "cluster-1",
 								corev1.LabelTopologyZone:   "pve-1",
 							},
 						},
 					},
 				},
 			},
-			expectedError: status.Error(codes.Internal, "proxmox cluster Region-1 not found"),
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist-same-size",
+					VolumeContext: map[string]string{
+						"storage": "rbd,local-lvm",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "StorageListNoSpace",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-new",
+				Parameters: map[string]string{
+					"storage": "rbd,local-lvm",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange: &proto.CapacityRange{
+					RequiredBytes: 300 * 1024 * 1024 * 1024,
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.ResourceExhausted, "failed to find storage with enough free space on node pve-1"),
+		},
+		{
+			msg: "FormatInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local",
+					"format":  "vdi",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters format must be raw, qcow2 or vmdk"),
+		},
+		{
+			msg: "FormatNotSupported",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-qcow2",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"format":  "qcow2",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "disk format qcow2 is not supported on storage local-lvm"),
+		},
+		{
+			msg: "FormatQcow2",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-qcow2",
+				Parameters: map[string]string{
+					"storage": "local",
+					"format":  "qcow2",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/local/9999/vm-9999-pvc-qcow2.qcow2",
+					VolumeContext: map[string]string{
+						"storage": "local",
+						"format":  "qcow2",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "DiskQoSInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":           "local-lvm",
+					"diskReadIOPSBurst": "-100",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters diskReadIOPSBurst must be a positive number"),
+		},
+		{
+			msg: "AIOInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"aio":     "posix",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters aio must be io_uring, native or threads"),
+		},
+		{
+			msg: "AIONativeCache",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"aio":     "native",
+					"cache":   "writeback",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters cache must be none or directsync for native aio"),
+		},
+		{
+			msg: "BackupInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"backup":  "yes",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters backup must be true or false"),
+		},
+		{
+			msg: "UnknownParameter",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":  "local-lvm",
+					"diskIops": "1000",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters diskIops is unknown"),
+		},
+		{
+			msg: "CacheInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"cache":   "unsafe",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters cache must be directsync, none, writeback or writethrough"),
+		},
+		{
+			msg: "PVCMetadata",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10",
+				Parameters: map[string]string{
+					"storage":                          "rbd",
+					"csi.storage.k8s.io/pvc/name":      "data-postgres-0",
+					"csi.storage.k8s.io/pvc/namespace": "default",
+					"csi.storage.k8s.io/pv/name":       "pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange: &proto.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/rbd/vm-9999-pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10.default.data-postgres-0",
+					VolumeContext: map[string]string{
+						"storage":                          "rbd",
+						"csi.storage.k8s.io/pvc/name":      "data-postgres-0",
+						"csi.storage.k8s.io/pvc/namespace": "default",
+						"csi.storage.k8s.io/pv/name":       "pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10",
+					},
+					CapacityBytes: int64(2 * 1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "DiskNameTemplate",
+			request: &proto.CreateVolumeRequest{
+				Name: "exist-same-size",
+				Parameters: map[string]string{
+					"storage":          "local-lvm",
+					"diskNameTemplate": "pvc-${pv.name}",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist-same-size",
+					VolumeContext: map[string]string{
+						"storage":          "local-lvm",
+						"diskNameTemplate": "pvc-${pv.name}",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "DiskNameTemplateInvalid",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":          "local-lvm",
+					"diskNameTemplate": "${pvc.name}",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters diskNameTemplate: template \"${pvc.name}\" must have the ${pv.name} placeholder"),
+		},
+		{
+			msg: "ZoneWeights",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage":     "local-lvm",
+					"zoneWeights": "pve-1=abc",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcap},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters zoneWeights: zone weight \"pve-1=abc\" must be a positive number"),
+		},
+		{
+			msg: "BestZone",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-zone",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-2/local-lvm/vm-9999-pvc-zone",
+					VolumeContext: volParam,
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-2",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "BestZoneWeights",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-exist-same-size",
+				Parameters: map[string]string{
+					"storage":     "local-lvm",
+					"zoneWeights": "pve-2=0",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist-same-size",
+					VolumeContext: map[string]string{
+						"storage":     "local-lvm",
+						"zoneWeights": "pve-2=0",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "BestZoneRequisite",
+			request: &proto.CreateVolumeRequest{
+				Name:               "pvc-exist-same-size",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Requisite: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist-same-size",
+					VolumeContext: volParam,
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "EmptyRegion",
+			request: &proto.CreateVolumeRequest{
+				Name:               "volume-id",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyZone: "zone",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.Internal, "cannot find best region"),
+		},
+		{
+			msg: "UnknowRegion",
+			request: &proto.CreateVolumeRequest{
+				Name:               "volume-id",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "unknown-region",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.Internal, "proxmox cluster unknown-region not found"),
+		},
+		{
+			msg: "BlockSMB",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "smb",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcapShared},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "raw block volumes are not supported on cifs storage"),
+		},
+		{
+			msg: "CreateVolumeSMB",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-smb",
+				Parameters: map[string]string{
+					"storage": "smb",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{
+					{
+						AccessMode: volcapShared.AccessMode,
+						AccessType: volcap.AccessType,
+					},
+				},
+				CapacityRange: volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/smb/9999/vm-9999-pvc-smb.subvol",
+					VolumeContext: map[string]string{
+						"storage":      "smb",
+						"mountSource":  "//10.0.0.1/data/images/9999/vm-9999-pvc-smb.subvol",
+						"mountFSType":  "cifs",
+						"mountOptions": "",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "MultiNodeNonShared",
+			request: &proto.CreateVolumeRequest{
+				Name:               "volume-id",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcapShared},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters shared must be true for multi-node access mode"),
+		},
+		{
+			msg: "MultiNodeFilesystem",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "rbd",
+					"shared":  "true",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{
+					{
+						AccessMode: volcapShared.AccessMode,
+						AccessType: volcap.AccessType,
+					},
+				},
+				CapacityRange: volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "multi-node access mode is supported only for raw block volumes"),
+		},
+		{
+			msg: "SharedCache",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "rbd",
+					"shared":  "true",
+					"cache":   "writeback",
+				},
+				VolumeCapabilities:        []*proto.VolumeCapability{volcapShared},
+				CapacityRange:             volsize,
+				AccessibilityRequirements: topology,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters cache must be none or directsync for shared volumes"),
+		},
+		{
+			msg: "SharedLocalStorage",
+			request: &proto.CreateVolumeRequest{
+				Name: "volume-id",
+				Parameters: map[string]string{
+					"storage": "local-lvm",
+					"shared":  "true",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcapShared},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "storage local-lvm is not shared, it cannot be attached to many nodes"),
+		},
+		{
+			msg: "SharedVolume",
+			request: &proto.CreateVolumeRequest{
+				Name: "pvc-shared",
+				Parameters: map[string]string{
+					"storage": "rbd",
+					"shared":  "true",
+				},
+				VolumeCapabilities: []*proto.VolumeCapability{volcapShared},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expected: &proto.CreateVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId: "cluster-1/pve-1/rbd/vm-9999-pvc-shared",
+					VolumeContext: map[string]string{
+						"storage": "rbd",
+						"shared":  "true",
+					},
+					CapacityBytes: int64(1024 * 1024 * 1024),
+					AccessibleTopology: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "cluster-1",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			msg: "WrongClusterNotFound",
+			request: &proto.CreateVolumeRequest{
+				Name:               "volume-id",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "fake-region",
+								corev1.LabelTopologyZone:   "zone",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.Internal, "proxmox cluster fake-region not found"),
+		},
+		{
+			msg: "WrongCluster",
+			request: &proto.CreateVolumeRequest{
+				Name:               "volume-id",
+				Parameters:         volParam,
+				VolumeCapabilities: []*proto.VolumeCapability{volcap},
+				CapacityRange:      volsize,
+				AccessibilityRequirements: &proto.TopologyRequirement{
+					Preferred: []*proto.Topology{
+						{
+							Segments: map[string]string{
+								corev1.LabelTopologyRegion: "Region-1",
+								corev1.LabelTopologyZone:   "pve-1",
+							},
+						},
+					},
+				},
+			},
+			expectedError: status.Error(codes.Internal, "proxmox cluster Region-1 not found"),
 		},
 		{
 			msg: "PVCAlreadyExist",
@@ -648,6 +1625,76 @@ func (ts *csiTestSuite) TestCreateVolume() {
 }
 
 //nolint:dupl
+func (ts *csiTestSuite) TestCreateVolumeRetry() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	avail := map[string]int{"pve-1": 50, "pve-2": 80}
+	content := map[string][]interface{}{"pve-1": {}, "pve-2": {}}
+
+	for _, node := range []string{"pve-1", "pve-2"} {
+		node := node
+
+		httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/"+node+"/storage/local-lvm/status",
+			func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewJsonResponse(200, map[string]interface{}{
+					"data": map[string]interface{}{
+						"type":  "lvmthin",
+						"total": 100 * 1024 * 1024 * 1024,
+						"avail": avail[node] * 1024 * 1024 * 1024,
+					},
+				})
+			},
+		)
+
+		httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/"+node+"/storage/local-lvm/content",
+			func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewJsonResponse(200, map[string]interface{}{"data": content[node]})
+			},
+		)
+
+		httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/"+node+"/storage/local-lvm/content",
+			func(req *http.Request) (*http.Response, error) {
+				content[node] = append(content[node], map[string]interface{}{
+					"format": "raw",
+					"size":   1024 * 1024 * 1024,
+					"volid":  "local-lvm:vm-9999-pvc-retry",
+				})
+
+				return httpmock.NewJsonResponse(200, map[string]interface{}{"data": "local-lvm:vm-9999-pvc-retry"})
+			},
+		)
+	}
+
+	request := &proto.CreateVolumeRequest{
+		Name:       "pvc-retry",
+		Parameters: map[string]string{"storage": "local-lvm"},
+		VolumeCapabilities: []*proto.VolumeCapability{{
+			AccessMode: &proto.VolumeCapability_AccessMode{Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			AccessType: &proto.VolumeCapability_Mount{Mount: &proto.VolumeCapability_MountVolume{FsType: "ext4"}},
+		}},
+		CapacityRange: &proto.CapacityRange{RequiredBytes: 1024 * 1024 * 1024},
+		AccessibilityRequirements: &proto.TopologyRequirement{
+			Preferred: []*proto.Topology{{Segments: map[string]string{corev1.LabelTopologyRegion: "cluster-1"}}},
+		},
+	}
+
+	resp, err := ts.s.CreateVolume(context.Background(), request)
+	ts.Require().NoError(err)
+	ts.Require().Equal("cluster-1/pve-2/local-lvm/vm-9999-pvc-retry", resp.GetVolume().GetVolumeId())
+
+	// The retried request finds the volume, even though the other node has more free space now
+	avail["pve-2"] = 10
+
+	resp, err = ts.s.CreateVolume(context.Background(), request)
+	ts.Require().NoError(err)
+	ts.Require().Equal("cluster-1/pve-2/local-lvm/vm-9999-pvc-retry", resp.GetVolume().GetVolumeId())
+
+	calls := httpmock.GetCallCountInfo()
+	ts.Require().Equal(0, calls["POST https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local-lvm/content"])
+	ts.Require().Equal(1, calls["POST https://127.0.0.1:8006/api2/json/nodes/pve-2/storage/local-lvm/content"])
+}
+
 func (ts *csiTestSuite) TestDeleteVolume() {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -724,12 +1771,60 @@ func (ts *csiTestSuite) TestDeleteVolume() {
 	}
 }
 
+func (ts *csiTestSuite) TestDeleteVolumeCached() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ts.s.CacheTTL = time.Minute
+
+	for _, volumeID := range []string{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+	} {
+		_, err := ts.s.DeleteVolume(context.Background(), &proto.DeleteVolumeRequest{VolumeId: volumeID})
+		ts.Require().NoError(err)
+	}
+
+	// The storage content is read again only after the volume is deleted
+	calls := httpmock.GetCallCountInfo()
+	ts.Require().Equal(2, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local-lvm/content"])
+	ts.Require().Equal(2, calls["DELETE https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local-lvm/content/vm-9999-pvc-123"])
+}
+
+func (ts *csiTestSuite) TestGetCapacityCached() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ts.s.CacheTTL = time.Minute
+
+	for i := 0; i < 2; i++ {
+		resp, err := ts.s.GetCapacity(context.Background(), &proto.GetCapacityRequest{
+			AccessibleTopology: &proto.Topology{
+				Segments: map[string]string{
+					corev1.LabelTopologyRegion: "cluster-1",
+				},
+			},
+			Parameters: map[string]string{
+				csi.StorageSelectorKey: "type=rbd",
+			},
+		})
+		ts.Require().NoError(err)
+		ts.Require().Equal(int64(200*1024*1024*1024), resp.GetAvailableCapacity())
+	}
+
+	// The storage list of the selector is read once
+	calls := httpmock.GetCallCountInfo()
+	ts.Require().Equal(1, calls["GET https://127.0.0.1:8006/api2/json/storage"])
+}
+
 func (ts *csiTestSuite) TestControllerServiceControllerGetCapabilities() {
 	resp, err := ts.s.ControllerGetCapabilities(context.Background(), &proto.ControllerGetCapabilitiesRequest{})
 	ts.Require().NoError(err)
 	ts.Require().NotNil(resp)
 
-	if len(resp.Capabilities) != 6 {
+	if len(resp.Capabilities) != 8 {
 		ts.T().Fatalf("unexpected number of capabilities: %d", len(resp.Capabilities))
 	}
 }
@@ -813,6 +1908,35 @@ func (ts *csiTestSuite) TestControllerPublishVolumeError() {
 			},
 			expectedError: status.Error(codes.Internal, "proxmox cluster fake-region not found"),
 		},
+		{
+			msg: "NetworkVolume",
+			request: &proto.ControllerPublishVolumeRequest{
+				NodeId:           "cluster-1-node-1",
+				VolumeId:         "cluster-1/pve-1/smb/9999/vm-9999-pvc-smb.subvol",
+				VolumeCapability: volcap,
+				VolumeContext: map[string]string{
+					csi.MountSourceKey: "//10.0.0.1/data/images/9999/vm-9999-pvc-smb.subvol",
+				},
+			},
+			expected: &proto.ControllerPublishVolumeResponse{},
+		},
+		{
+			msg: "MultiNodeNonShared",
+			request: &proto.ControllerPublishVolumeRequest{
+				NodeId:   "cluster-1-node-1",
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapability: &proto.VolumeCapability{
+					AccessMode: &proto.VolumeCapability_AccessMode{
+						Mode: proto.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+					AccessType: &proto.VolumeCapability_Block{
+						Block: &proto.VolumeCapability_BlockVolume{},
+					},
+				},
+				VolumeContext: volCtx,
+			},
+			expectedError: status.Error(codes.InvalidArgument, "multi-node access mode is supported only for shared volumes"),
+		},
 		// {
 		// 	msg: "WrongNode",
 		// 	request: &proto.ControllerPublishVolumeRequest{
@@ -824,6 +1948,20 @@ func (ts *csiTestSuite) TestControllerPublishVolumeError() {
 		// 	},
 		// 	expectedError: status.Error(codes.InvalidArgument, "volume cluster-1/pve-1/local-lvm/vm-9999-pvc-123 does not exist on the node cluster-1-node-2"),
 		// },
+		{
+			msg: "DiskQoSInvalid",
+			request: &proto.ControllerPublishVolumeRequest{
+				NodeId:           "cluster-1-node-1",
+				VolumeId:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapability: volcap,
+				VolumeContext: map[string]string{
+					csi.StorageIDKey:            "local-lvm",
+					csi.StorageDiskReadIOPSKey:  "1000",
+					csi.StorageDiskWriteMBpsKey: "fast",
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters diskWriteMBps must be a positive number"),
+		},
 		{
 			msg: "VolumeNotExist",
 			request: &proto.ControllerPublishVolumeRequest{
@@ -832,21 +1970,82 @@ func (ts *csiTestSuite) TestControllerPublishVolumeError() {
 				VolumeCapability: volcap,
 				VolumeContext:    volCtx,
 			},
-			expectedError: status.Error(codes.NotFound, "failed to find volume"),
+			expectedError: status.Error(codes.NotFound, "failed to find volume"),
+		},
+		{
+			msg: "VolumeAlreadyAttached",
+			request: &proto.ControllerPublishVolumeRequest{
+				NodeId:           "cluster-1-node-1",
+				VolumeId:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+				VolumeCapability: volcap,
+				VolumeContext:    volCtx,
+			},
+			expected: &proto.ControllerPublishVolumeResponse{
+				PublishContext: map[string]string{
+					"DevicePath": "/dev/disk/by-id/wwn-0x5056432d49443031",
+					"lun":        "1",
+				},
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ControllerPublishVolume(context.Background(), testCase.request)
+
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(resp, testCase.expected)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
+}
+
+func (ts *csiTestSuite) TestControllerPublishVolumeDiskOptions() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		msg             string
+		volCtx          map[string]string
+		expectedOptions map[string]string
+	}{
+		{
+			msg: "DiskQoS",
+			volCtx: map[string]string{
+				csi.StorageDiskIOPSKey:      "1000",
+				csi.StorageDiskMBpsKey:      "100",
+				csi.StorageDiskMBpsBurstKey: "500",
+			},
+			expectedOptions: map[string]string{
+				"backup":   "0",
+				"iothread": "1",
+				"iops":     "1000",
+				"mbps":     "100",
+				"mbps_max": "500",
+				"wwn":      "0x5056432d49443032",
+			},
 		},
 		{
-			msg: "VolumeAlreadyAttached",
-			request: &proto.ControllerPublishVolumeRequest{
-				NodeId:           "cluster-1-node-1",
-				VolumeId:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
-				VolumeCapability: volcap,
-				VolumeContext:    volCtx,
+			msg: "AIOBackupReplicate",
+			volCtx: map[string]string{
+				csi.StorageAIOKey:       "native",
+				csi.StorageCacheKey:     "none",
+				csi.StorageBackupKey:    "true",
+				csi.StorageReplicateKey: "false",
 			},
-			expected: &proto.ControllerPublishVolumeResponse{
-				PublishContext: map[string]string{
-					"DevicePath": "/dev/disk/by-id/wwn-0x5056432d49443031",
-					"lun":        "1",
-				},
+			expectedOptions: map[string]string{
+				"aio":       "native",
+				"cache":     "none",
+				"backup":    "1",
+				"replicate": "0",
+				"iothread":  "1",
+				"wwn":       "0x5056432d49443032",
 			},
 		},
 	}
@@ -855,19 +2054,88 @@ func (ts *csiTestSuite) TestControllerPublishVolumeError() {
 		testCase := testCase
 
 		ts.Run(fmt.Sprint(testCase.msg), func() {
-			resp, err := ts.s.ControllerPublishVolume(context.Background(), testCase.request)
+			// The attached disk is shown in the VM config after the config request
+			device := ""
 
-			if testCase.expectedError == nil {
-				ts.Require().NoError(err)
-				ts.Require().Equal(resp, testCase.expected)
-			} else {
-				ts.Require().Error(err)
-				ts.Require().Equal(testCase.expectedError, err)
+			httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config",
+				func(req *http.Request) (*http.Response, error) {
+					if err := req.ParseForm(); err != nil {
+						return nil, err
+					}
+
+					device = req.PostForm.Get("scsi2")
+
+					return httpmock.NewJsonResponse(200, map[string]interface{}{})
+				},
+			)
+
+			httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config",
+				func(req *http.Request) (*http.Response, error) {
+					config := map[string]interface{}{
+						"vmid":  100,
+						"scsi0": "local-lvm:vm-100-disk-0,size=10G",
+						"scsi1": "local-lvm:vm-9999-pvc-123,backup=0,iothread=1,wwn=0x5056432d49443031",
+					}
+
+					if device != "" {
+						config["scsi2"] = device
+					}
+
+					return httpmock.NewJsonResponse(200, map[string]interface{}{"data": config})
+				},
+			)
+
+			_, err := ts.s.ControllerPublishVolume(context.Background(), &proto.ControllerPublishVolumeRequest{
+				NodeId:   "cluster-1-node-1",
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+				VolumeCapability: &proto.VolumeCapability{
+					AccessMode: &proto.VolumeCapability_AccessMode{
+						Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+				VolumeContext: testCase.volCtx,
+			})
+			ts.Require().NoError(err)
+
+			parts := strings.Split(device, ",")
+			ts.Require().Equal("local-lvm:vm-9999-pvc-exist", parts[0])
+
+			options := map[string]string{}
+
+			for _, option := range parts[1:] {
+				key, value, _ := strings.Cut(option, "=")
+				options[key] = value
 			}
+
+			ts.Require().Equal(testCase.expectedOptions, options)
 		})
 	}
 }
 
+func (ts *csiTestSuite) TestControllerPublishVolumeCanceled() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ts.s.ControllerPublishVolume(ctx, &proto.ControllerPublishVolumeRequest{
+		NodeId:   "cluster-1-node-1",
+		VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+		VolumeCapability: &proto.VolumeCapability{
+			AccessMode: &proto.VolumeCapability_AccessMode{
+				Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		VolumeContext: map[string]string{},
+	})
+	ts.Require().Error(err)
+	ts.Require().Equal(codes.Canceled, status.Code(err))
+
+	// The canceled request does not call Proxmox
+	ts.Require().Equal(0, httpmock.GetTotalCallCount())
+}
+
 //nolint:dupl
 func (ts *csiTestSuite) TestControllerUnpublishVolumeError() {
 	httpmock.Activate()
@@ -914,7 +2182,7 @@ func (ts *csiTestSuite) TestControllerUnpublishVolumeError() {
 				NodeId:   "cluster-1-node-3",
 				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
 			},
-			expectedError: status.Error(codes.Internal, "vm 'cluster-1-node-3' not found"),
+			expectedError: status.Error(codes.NotFound, "vm 'cluster-1-node-3' not found"),
 		},
 		{
 			msg: "AlreadyDetached",
@@ -948,9 +2216,117 @@ func (ts *csiTestSuite) TestValidateVolumeCapabilities() {
 }
 
 func (ts *csiTestSuite) TestListVolumes() {
-	_, err := ts.s.ListVolumes(context.Background(), &proto.ListVolumesRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		msg           string
+		request       *proto.ListVolumesRequest
+		expected      *proto.ListVolumesResponse
+		expectedError error
+	}{
+		{
+			msg: "InvalidToken",
+			request: &proto.ListVolumesRequest{
+				StartingToken: "abc",
+			},
+			expectedError: status.Error(codes.Aborted, "invalid starting token abc"),
+		},
+		{
+			msg: "TokenOutOfRange",
+			request: &proto.ListVolumesRequest{
+				StartingToken: "100",
+			},
+			expectedError: status.Error(codes.Aborted, "invalid starting token 100"),
+		},
+		{
+			msg: "FirstPage",
+			request: &proto.ListVolumesRequest{
+				MaxEntries: 1,
+			},
+			expected: &proto.ListVolumesResponse{
+				Entries: []*proto.ListVolumesResponse_Entry{
+					{
+						Volume: &proto.Volume{
+							VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+							CapacityBytes: 1024 * 1024 * 1024,
+							AccessibleTopology: []*proto.Topology{
+								{
+									Segments: map[string]string{
+										corev1.LabelTopologyRegion: "cluster-1",
+										corev1.LabelTopologyZone:   "pve-1",
+									},
+								},
+							},
+						},
+						Status: &proto.ListVolumesResponse_VolumeStatus{
+							PublishedNodeIds: []string{"cluster-1-node-1"},
+						},
+					},
+				},
+				NextToken: "1",
+			},
+		},
+		{
+			msg: "LastPage",
+			request: &proto.ListVolumesRequest{
+				StartingToken: "4",
+			},
+			expected: &proto.ListVolumesResponse{
+				Entries: []*proto.ListVolumesResponse_Entry{
+					{
+						Volume: &proto.Volume{
+							VolumeId:      "cluster-1/pve-1/rbd/vm-9999-pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10.default.data-postgres-0",
+							CapacityBytes: 2 * 1024 * 1024 * 1024,
+							VolumeContext: map[string]string{
+								csi.PVNameKey:       "pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10",
+								csi.PVCNamespaceKey: "default",
+								csi.PVCNameKey:      "data-postgres-0",
+							},
+							AccessibleTopology: []*proto.Topology{
+								{
+									Segments: map[string]string{
+										corev1.LabelTopologyRegion: "cluster-1",
+									},
+								},
+							},
+						},
+						Status: &proto.ListVolumesResponse_VolumeStatus{},
+					},
+					{
+						Volume: &proto.Volume{
+							VolumeId:      "cluster-1/pve-1/rbd/vm-9999-pvc-shared",
+							CapacityBytes: 1024 * 1024 * 1024,
+							AccessibleTopology: []*proto.Topology{
+								{
+									Segments: map[string]string{
+										corev1.LabelTopologyRegion: "cluster-1",
+									},
+								},
+							},
+						},
+						Status: &proto.ListVolumesResponse_VolumeStatus{},
+					},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ListVolumes(context.Background(), testCase.request)
+
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
 }
 
 func (ts *csiTestSuite) TestGetCapacity() {
@@ -973,21 +2349,39 @@ func (ts *csiTestSuite) TestGetCapacity() {
 			request: &proto.GetCapacityRequest{
 				AccessibleTopology: &proto.Topology{},
 			},
-			expectedError: status.Error(codes.InvalidArgument, "region, zone and storageName must be provided"),
+			expectedError: status.Error(codes.InvalidArgument, "region and storageName must be provided"),
 		},
 		{
 			msg: "TopologyRegion",
 			request: &proto.GetCapacityRequest{
 				AccessibleTopology: &proto.Topology{
 					Segments: map[string]string{
-						corev1.LabelTopologyRegion: "region",
+						corev1.LabelTopologyRegion: "cluster-1",
 					},
 				},
 				Parameters: map[string]string{
-					csi.StorageIDKey: "storage",
+					csi.StorageIDKey: "local-lvm",
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "zone must be provided for non-shared storage"),
+		},
+		{
+			msg: "TopologyRegionSharedStorage",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+					},
 				},
+				Parameters: map[string]string{
+					csi.StorageIDKey: "rbd",
+				},
+			},
+			expected: &proto.GetCapacityResponse{
+				AvailableCapacity: 200 * 1024 * 1024 * 1024,
+				MaximumVolumeSize: &wrappers.Int64Value{Value: 200 * 1024 * 1024 * 1024},
+				MinimumVolumeSize: &wrappers.Int64Value{Value: csi.MinVolumeSize * 1024 * 1024 * 1024},
 			},
-			expectedError: status.Error(codes.InvalidArgument, "region, zone and storageName must be provided"),
 		},
 		{
 			msg: "TopologyZone",
@@ -1001,7 +2395,7 @@ func (ts *csiTestSuite) TestGetCapacity() {
 					csi.StorageIDKey: "storage",
 				},
 			},
-			expectedError: status.Error(codes.InvalidArgument, "region, zone and storageName must be provided"),
+			expectedError: status.Error(codes.InvalidArgument, "region and storageName must be provided"),
 		},
 		{
 			msg: "TopologyStorageName",
@@ -1013,7 +2407,7 @@ func (ts *csiTestSuite) TestGetCapacity() {
 					},
 				},
 			},
-			expectedError: status.Error(codes.InvalidArgument, "region, zone and storageName must be provided"),
+			expectedError: status.Error(codes.InvalidArgument, "region and storageName must be provided"),
 		},
 		{
 			msg: "Topology",
@@ -1060,7 +2454,82 @@ func (ts *csiTestSuite) TestGetCapacity() {
 			},
 			expected: &proto.GetCapacityResponse{
 				AvailableCapacity: 50 * 1024 * 1024 * 1024,
+				MaximumVolumeSize: &wrappers.Int64Value{Value: 50 * 1024 * 1024 * 1024},
+				MinimumVolumeSize: &wrappers.Int64Value{Value: csi.MinVolumeSize * 1024 * 1024 * 1024},
+			},
+		},
+		{
+			msg: "StorageOvercommitRatio",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageIDKey:              "local-lvm",
+					csi.StorageOvercommitRatioKey: "3",
+				},
 			},
+			expected: &proto.GetCapacityResponse{
+				AvailableCapacity: 150 * 1024 * 1024 * 1024,
+				MaximumVolumeSize: &wrappers.Int64Value{Value: 100 * 1024 * 1024 * 1024},
+				MinimumVolumeSize: &wrappers.Int64Value{Value: csi.MinVolumeSize * 1024 * 1024 * 1024},
+			},
+		},
+		{
+			msg: "StorageList",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageIDKey: "local-lvm,rbd",
+				},
+			},
+			expected: &proto.GetCapacityResponse{
+				AvailableCapacity: 250 * 1024 * 1024 * 1024,
+				MaximumVolumeSize: &wrappers.Int64Value{Value: 200 * 1024 * 1024 * 1024},
+				MinimumVolumeSize: &wrappers.Int64Value{Value: csi.MinVolumeSize * 1024 * 1024 * 1024},
+			},
+		},
+		{
+			msg: "StorageSelectorRegion",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageSelectorKey: "content=images",
+				},
+			},
+			expected: &proto.GetCapacityResponse{
+				AvailableCapacity: 200 * 1024 * 1024 * 1024,
+				MaximumVolumeSize: &wrappers.Int64Value{Value: 200 * 1024 * 1024 * 1024},
+				MinimumVolumeSize: &wrappers.Int64Value{Value: csi.MinVolumeSize * 1024 * 1024 * 1024},
+			},
+		},
+		{
+			msg: "StorageOvercommitRatioInvalid",
+			request: &proto.GetCapacityRequest{
+				AccessibleTopology: &proto.Topology{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+				Parameters: map[string]string{
+					csi.StorageIDKey:              "local-lvm",
+					csi.StorageOvercommitRatioKey: "0.5",
+				},
+			},
+			expectedError: status.Error(codes.InvalidArgument, "Parameters overcommitRatio: overcommit ratio \"0.5\" must be a number greater than or equal to 1"),
 		},
 	}
 
@@ -1176,13 +2645,16 @@ func (ts *csiTestSuite) TestControllerExpandVolumeError() {
 			expected: &proto.ControllerExpandVolumeResponse{},
 		},
 		{
-			msg: "UpublishedVolume",
+			msg: "UnpublishedVolume",
 			request: &proto.ControllerExpandVolumeRequest{
 				VolumeId:         "cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
 				CapacityRange:    capRange,
 				VolumeCapability: volCapability,
 			},
-			expectedError: status.Error(codes.Internal, "cannot resize unpublished volumeID"),
+			expected: &proto.ControllerExpandVolumeResponse{
+				CapacityBytes:         100,
+				NodeExpansionRequired: true,
+			},
 		},
 		{
 			msg: "ExpandVolume",
@@ -1213,10 +2685,145 @@ func (ts *csiTestSuite) TestControllerExpandVolumeError() {
 			}
 		})
 	}
+
+	// The temporary VM of the offline resize is removed after the volume is detached
+	calls := httpmock.GetCallCountInfo()
+	ts.Require().Equal(1, calls["PUT https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/resize"])
+	ts.Require().Equal(1, calls["PUT https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/unlink"])
+	ts.Require().Equal(1, calls["DELETE https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110"])
+}
+
+func (ts *csiTestSuite) TestControllerExpandVolumeDetachedPublish() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	var publishErr error
+
+	// The volume is published while it is attached to the temporary VM
+	httpmock.RegisterResponder("PUT", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/110/resize",
+		func(req *http.Request) (*http.Response, error) {
+			_, publishErr = ts.s.ControllerPublishVolume(context.Background(), &proto.ControllerPublishVolumeRequest{
+				NodeId:   "cluster-1-node-1",
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+				VolumeCapability: &proto.VolumeCapability{
+					AccessMode: &proto.VolumeCapability_AccessMode{
+						Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+				VolumeContext: map[string]string{},
+			})
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	_, err := ts.s.ControllerExpandVolume(context.Background(), &proto.ControllerExpandVolumeRequest{
+		VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+		CapacityRange: &proto.CapacityRange{RequiredBytes: 100},
+		VolumeCapability: &proto.VolumeCapability{
+			AccessMode: &proto.VolumeCapability_AccessMode{
+				Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal(status.Error(codes.Aborted, "vm-9999-pvc-error: volume is being resized"), publishErr)
 }
 
 func (ts *csiTestSuite) TestControllerGetVolume() {
-	_, err := ts.s.ControllerGetVolume(context.Background(), &proto.ControllerGetVolumeRequest{})
-	ts.Require().Error(err)
-	ts.Require().Equal(status.Error(codes.Unimplemented, ""), err)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	tests := []struct {
+		msg           string
+		request       *proto.ControllerGetVolumeRequest
+		expected      *proto.ControllerGetVolumeResponse
+		expectedError error
+	}{
+		{
+			msg:           "VolumeID",
+			request:       &proto.ControllerGetVolumeRequest{},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be provided"),
+		},
+		{
+			msg: "WrongVolumeID",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "volume-id",
+			},
+			expectedError: status.Error(codes.InvalidArgument, "VolumeID must be in the format of region/zone/storageName/diskName"),
+		},
+		{
+			msg: "NonExistVolume",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist",
+			},
+			expectedError: status.Error(codes.NotFound, "volume cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist not found"),
+		},
+		{
+			msg: "PublishedVolume",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-123",
+					CapacityBytes: 1024 * 1024 * 1024,
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					PublishedNodeIds: []string{"cluster-1-node-1"},
+				},
+			},
+		},
+		{
+			msg: "UnpublishedVolume",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+					CapacityBytes: 5 * 1024 * 1024 * 1024,
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					PublishedNodeIds: []string{},
+				},
+			},
+		},
+		{
+			msg: "UnpublishedVolumeCached",
+			request: &proto.ControllerGetVolumeRequest{
+				VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+			},
+			expected: &proto.ControllerGetVolumeResponse{
+				Volume: &proto.Volume{
+					VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+					CapacityBytes: 5 * 1024 * 1024 * 1024,
+				},
+				Status: &proto.ControllerGetVolumeResponse_VolumeStatus{
+					PublishedNodeIds: []string{},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		ts.Run(fmt.Sprint(testCase.msg), func() {
+			resp, err := ts.s.ControllerGetVolume(context.Background(), testCase.request)
+
+			if testCase.expectedError == nil {
+				ts.Require().NoError(err)
+				ts.Require().Equal(testCase.expected, resp)
+			} else {
+				ts.Require().Error(err)
+				ts.Require().Equal(testCase.expectedError, err)
+			}
+		})
+	}
+
+	// The index is built by the first request, the not attached volume reads the VM configs of its node again only once
+	calls := httpmock.GetCallCountInfo()
+	ts.Require().Equal(2, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config"])
+	ts.Require().Equal(1, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-2/qemu/101/config"])
 }
@@ -0,0 +1,297 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// SeverityOK is the finding of the valid object
+	SeverityOK = "ok"
+	// SeverityWarning is the finding of the object which may fail in some cases
+	SeverityWarning = "warning"
+	// SeverityError is the finding of the object which does not work
+	SeverityError = "error"
+)
+
+// Finding is the result of the check of one Kubernetes object.
+type Finding struct {
+	Severity string `json:"severity"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+}
+
+// regionInventory is the Proxmox nodes, VMs and storages of the region.
+type regionInventory struct {
+	err error
+
+	nodes []string
+	// vms are the Proxmox nodes of the VMs by the VM name
+	vms map[string][]string
+	// storages are the Proxmox nodes with the active storage by the storage name
+	storages map[string][]string
+}
+
+// Diagnose checks the topology labels of the Kubernetes nodes and the storages of the StorageClasses of the plugin
+// against the Proxmox clusters.
+func (d *ControllerService) Diagnose(ctx context.Context, clientset clientkubernetes.Interface) ([]Finding, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	storageClasses, err := clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list StorageClasses: %v", err)
+	}
+
+	inventories := map[string]*regionInventory{}
+	for _, region := range d.Cluster.Regions() {
+		inventories[region] = d.getRegionInventory(ctx, region)
+	}
+
+	findings := []Finding{}
+
+	for i := range nodes.Items {
+		findings = append(findings, diagnoseNode(&nodes.Items[i], inventories))
+	}
+
+	for i := range storageClasses.Items {
+		if storageClasses.Items[i].Provisioner != DriverName {
+			continue
+		}
+
+		findings = append(findings, diagnoseStorageClass(&storageClasses.Items[i], d.Cluster.Regions(), inventories))
+	}
+
+	return findings, ctx.Err()
+}
+
+func (d *ControllerService) getRegionInventory(ctx context.Context, region string) *regionInventory {
+	inv := &regionInventory{vms: map[string][]string{}, storages: map[string][]string{}}
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, region)
+	if err != nil {
+		inv.err = err
+
+		return inv
+	}
+
+	nodes, err := cl.GetNodeList()
+	if err != nil {
+		inv.err = fmt.Errorf("failed to get node list: %v", err)
+
+		return inv
+	}
+
+	if items, ok := nodes["data"].([]interface{}); ok {
+		for _, item := range items {
+			if node, ok := item.(map[string]interface{}); ok {
+				if name, ok := node["node"].(string); ok {
+					inv.nodes = append(inv.nodes, name)
+				}
+			}
+		}
+	}
+
+	vms, err := cl.GetResourceList("vm")
+	if err != nil {
+		inv.err = fmt.Errorf("failed to get VM list: %v", err)
+
+		return inv
+	}
+
+	for _, item := range vms {
+		vm, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _ := vm["name"].(string) //nolint:errcheck
+		node, _ := vm["node"].(string) //nolint:errcheck
+
+		if name != "" {
+			inv.vms[name] = append(inv.vms[name], node)
+		}
+	}
+
+	storages, err := cl.GetResourceList("storage")
+	if err != nil {
+		inv.err = fmt.Errorf("failed to get storage list: %v", err)
+
+		return inv
+	}
+
+	for _, item := range storages {
+		storage, ok := item.(map[string]interface{})
+		if !ok || storage["type"] != "storage" || storage["status"] != "available" {
+			continue
+		}
+
+		name, _ := storage["storage"].(string) //nolint:errcheck
+		node, _ := storage["node"].(string)    //nolint:errcheck
+
+		inv.storages[name] = append(inv.storages[name], node)
+	}
+
+	return inv
+}
+
+// diagnoseNode checks that the region and zone labels of the node are the Proxmox cluster and node,
+// and the node name is the name of exactly one VM, ControllerPublishVolume finds the VM by the node name.
+func diagnoseNode(node *corev1.Node, inventories map[string]*regionInventory) Finding {
+	finding := func(severity, format string, args ...interface{}) Finding {
+		return Finding{Severity: severity, Kind: "Node", Name: node.Name, Message: fmt.Sprintf(format, args...)}
+	}
+
+	region := node.Labels[corev1.LabelTopologyRegion]
+	zone := node.Labels[corev1.LabelTopologyZone]
+
+	if region == "" || zone == "" {
+		return finding(SeverityError, "the node has no %s or %s label", corev1.LabelTopologyRegion, corev1.LabelTopologyZone)
+	}
+
+	inv, ok := inventories[region]
+	if !ok {
+		return finding(SeverityError, "region %s is not in the cloud config", region)
+	}
+
+	if inv.err != nil {
+		return finding(SeverityError, "region %s cannot be checked: %v", region, inv.err)
+	}
+
+	if !slices.Contains(inv.nodes, zone) {
+		return finding(SeverityError, "zone %s is not a Proxmox node of region %s", zone, region)
+	}
+
+	vms := inv.vms[node.Name]
+
+	switch {
+	case len(vms) == 0:
+		return finding(SeverityError, "no VM with name %s in region %s", node.Name, region)
+	case len(vms) > 1:
+		return finding(SeverityError, "%d VMs with name %s in region %s, the volumes can be attached to the wrong one", len(vms), node.Name, region)
+	case vms[0] != zone:
+		return finding(SeverityWarning, "the VM is on Proxmox node %s, but the zone is %s", vms[0], zone)
+	}
+
+	return finding(SeverityOK, "the VM is on Proxmox node %s of region %s", zone, region)
+}
+
+// diagnoseStorageClass checks that at least one storage of the StorageClass is available on each zone the allowed topologies allow.
+// If the StorageClass has no allowed topologies, the storages can be missing on some zones, it is a warning.
+func diagnoseStorageClass(sc *storagev1.StorageClass, regions []string, inventories map[string]*regionInventory) Finding {
+	finding := func(severity, format string, args ...interface{}) Finding {
+		return Finding{Severity: severity, Kind: "StorageClass", Name: sc.Name, Message: fmt.Sprintf(format, args...)}
+	}
+
+	storages := parseStorageList(sc.Parameters[StorageIDKey])
+	if len(storages) == 0 {
+		if sc.Parameters[StorageSelectorKey] != "" {
+			return finding(SeverityOK, "the storage is chosen by %s, it is not checked", StorageSelectorKey)
+		}
+
+		return finding(SeverityError, "the StorageClass has no %s parameter", StorageIDKey)
+	}
+
+	type zoneRef struct{ region, zone string }
+
+	zones := []zoneRef{}
+	unknown := []string{}
+
+	terms := sc.AllowedTopologies
+	if len(terms) == 0 {
+		terms = []corev1.TopologySelectorTerm{{}}
+	}
+
+	for _, term := range terms {
+		termRegions := regions
+		termZones := []string(nil)
+
+		for _, expr := range term.MatchLabelExpressions {
+			switch expr.Key {
+			case corev1.LabelTopologyRegion:
+				termRegions = expr.Values
+			case corev1.LabelTopologyZone:
+				termZones = expr.Values
+			}
+		}
+
+		for _, region := range termRegions {
+			inv, ok := inventories[region]
+			if !ok {
+				unknown = append(unknown, region)
+
+				continue
+			}
+
+			if inv.err != nil {
+				return finding(SeverityError, "region %s cannot be checked: %v", region, inv.err)
+			}
+
+			regionZones := termZones
+			if regionZones == nil {
+				regionZones = inv.nodes
+			}
+
+			for _, zone := range regionZones {
+				zones = append(zones, zoneRef{region: region, zone: zone})
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		return finding(SeverityError, "allowed regions %s are not in the cloud config", strings.Join(unknown, ", "))
+	}
+
+	missing := []string{}
+
+	for _, z := range zones {
+		available := slices.ContainsFunc(storages, func(storageName string) bool {
+			return slices.Contains(inventories[z.region].storages[storageName], z.zone)
+		})
+
+		if !available {
+			missing = append(missing, z.region+"/"+z.zone)
+		}
+	}
+
+	storageNames := strings.Join(storages, ",")
+
+	switch {
+	case len(zones) == 0:
+		return finding(SeverityError, "the allowed topologies have no zones")
+	case len(missing) == len(zones):
+		return finding(SeverityError, "storage %s is not available on any allowed zone", storageNames)
+	case len(missing) > 0 && len(sc.AllowedTopologies) == 0:
+		return finding(SeverityWarning, "storage %s is not available on zones %s, set allowedTopologies to exclude them", storageNames, strings.Join(missing, ", "))
+	case len(missing) > 0:
+		return finding(SeverityError, "storage %s is not available on allowed zones %s", storageNames, strings.Join(missing, ", "))
+	}
+
+	return finding(SeverityOK, "storage %s is available on %d zones", storageNames, len(zones))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi_test
+
+import (
+	"context"
+
+	"github.com/jarcoal/httpmock"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func (ts *csiTestSuite) TestDiagnose() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	node := func(name string, labels map[string]string) runtime.Object {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	storageClass := func(name, provisioner string, params map[string]string, topology map[string][]string) runtime.Object {
+		sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Provisioner: provisioner, Parameters: params}
+
+		if len(topology) > 0 {
+			term := corev1.TopologySelectorTerm{}
+			for key, values := range topology {
+				term.MatchLabelExpressions = append(term.MatchLabelExpressions, corev1.TopologySelectorLabelRequirement{Key: key, Values: values})
+			}
+
+			sc.AllowedTopologies = []corev1.TopologySelectorTerm{term}
+		}
+
+		return sc
+	}
+
+	zone := func(region, zone string) map[string]string {
+		return map[string]string{corev1.LabelTopologyRegion: region, corev1.LabelTopologyZone: zone}
+	}
+
+	clientset := fake.NewSimpleClientset(
+		node("cluster-1-node-1", zone("cluster-1", "pve-1")),
+		node("cluster-1-node-2", zone("cluster-1", "pve-1")),
+		node("cluster-1-node-3", zone("cluster-1", "pve-1")),
+		node("cluster-1-node-4", zone("cluster-1", "pve-9")),
+		node("cluster-2-node-1", zone("cluster-2", "pve-3")),
+		node("cluster-3-node-1", zone("cluster-3", "pve-1")),
+		node("no-labels", nil),
+		storageClass("rbd", csi.DriverName, map[string]string{csi.StorageIDKey: "rbd"}, map[string][]string{corev1.LabelTopologyRegion: {"cluster-1"}}),
+		storageClass("local-lvm", csi.DriverName, map[string]string{csi.StorageIDKey: "local-lvm"}, map[string][]string{corev1.LabelTopologyRegion: {"cluster-1"}}),
+		storageClass("local-lvm-rbd", csi.DriverName, map[string]string{csi.StorageIDKey: "local-lvm, rbd"}, map[string][]string{corev1.LabelTopologyRegion: {"cluster-1"}}),
+		storageClass("local-lvm-pve-1", csi.DriverName, map[string]string{csi.StorageIDKey: "local-lvm"},
+			map[string][]string{corev1.LabelTopologyRegion: {"cluster-1"}, corev1.LabelTopologyZone: {"pve-1"}}),
+		storageClass("any-region", csi.DriverName, map[string]string{csi.StorageIDKey: "rbd"}, nil),
+		storageClass("unknown-region", csi.DriverName, map[string]string{csi.StorageIDKey: "rbd"}, map[string][]string{corev1.LabelTopologyRegion: {"cluster-3"}}),
+		storageClass("selector", csi.DriverName, map[string]string{csi.StorageSelectorKey: "type=lvmthin"}, nil),
+		storageClass("no-storage", csi.DriverName, map[string]string{}, nil),
+		storageClass("other", "other.csi.k8s.io", map[string]string{}, nil),
+	)
+
+	findings, err := ts.s.Diagnose(context.Background(), clientset)
+	ts.Require().NoError(err)
+
+	severities := map[string]string{}
+	for _, f := range findings {
+		severities[f.Kind+"/"+f.Name] = f.Severity
+	}
+
+	ts.Require().Equal(map[string]string{
+		"Node/cluster-1-node-1":        csi.SeverityOK,
+		"Node/cluster-1-node-2":        csi.SeverityWarning,
+		"Node/cluster-1-node-3":        csi.SeverityError,
+		"Node/cluster-1-node-4":        csi.SeverityError,
+		"Node/cluster-2-node-1":        csi.SeverityError,
+		"Node/cluster-3-node-1":        csi.SeverityError,
+		"Node/no-labels":               csi.SeverityError,
+		"StorageClass/rbd":             csi.SeverityOK,
+		"StorageClass/local-lvm":       csi.SeverityError,
+		"StorageClass/local-lvm-rbd":   csi.SeverityOK,
+		"StorageClass/local-lvm-pve-1": csi.SeverityOK,
+		"StorageClass/any-region":      csi.SeverityError,
+		"StorageClass/unknown-region":  csi.SeverityError,
+		"StorageClass/selector":        csi.SeverityOK,
+		"StorageClass/no-storage":      csi.SeverityError,
+	}, severities)
+
+	for _, f := range findings {
+		switch f.Kind + "/" + f.Name {
+		case "Node/cluster-1-node-2":
+			ts.Require().Equal("the VM is on Proxmox node pve-2, but the zone is pve-1", f.Message)
+		case "Node/cluster-1-node-3":
+			ts.Require().Equal("no VM with name cluster-1-node-3 in region cluster-1", f.Message)
+		case "StorageClass/local-lvm":
+			ts.Require().Equal("storage local-lvm is not available on allowed zones cluster-1/pve-2", f.Message)
+		case "StorageClass/local-lvm-rbd":
+			ts.Require().Equal("storage local-lvm,rbd is available on 2 zones", f.Message)
+		}
+	}
+}
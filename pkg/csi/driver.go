@@ -25,17 +25,73 @@ const (
 	// DriverSpecVersion CSI spec version
 	DriverSpecVersion = "1.8.0"
 
-	// StorageIDKey is the ID of the Proxmox storage
+	// StorageIDKey is the ID of the Proxmox storage, or the ordered list of storages separated by comma
 	StorageIDKey = "storage"
+	// StorageSelectorKey selects the storages by type or content, in the format "type=lvmthin,content=images"
+	StorageSelectorKey = "storageSelector"
 	// StorageCacheKey is the cache type, can be one of "directsync", "none", "writeback", "writethrough"
 	StorageCacheKey = "cache"
 	// StorageSSDKey is it ssd disk
 	StorageSSDKey = "ssd"
+	// StorageAIOKey is the asynchronous I/O mode, can be one of "io_uring", "native", "threads"
+	StorageAIOKey = "aio"
+	// StorageIOThreadKey creates a dedicated I/O thread for the disk, enabled by default
+	StorageIOThreadKey = "iothread"
+	// StorageReplicateKey includes the disk in Proxmox storage replication jobs
+	StorageReplicateKey = "replicate"
+	// StorageBackupKey includes the disk in Proxmox backups, disabled by default
+	StorageBackupKey = "backup"
+	// StorageFormatKey is the disk image format on directory-based storages, can be one of "raw", "qcow2", "vmdk"
+	StorageFormatKey = "format"
+	// StorageDiskNameTemplateKey is the template of the disk name, placeholders: ${pv.name}, ${pvc.namespace}, ${pvc.name}
+	StorageDiskNameTemplateKey = "diskNameTemplate"
+	// StorageSharedKey allows to attach the volume to many VMs at once, raw block volumes on shared storage only
+	StorageSharedKey = "shared"
+
+	// StorageZoneWeightsKey is the weights of zones when choosing the best zone, in the format "zone1=2,zone2=0.5"
+	StorageZoneWeightsKey = "zoneWeights"
+
+	// StorageOvercommitRatioKey is the overcommit ratio of thin-provisioned storages, used to report the capacity
+	StorageOvercommitRatioKey = "overcommitRatio"
 
 	// StorageDiskIOPSKey is maximum r/w I/O in operations per second
 	StorageDiskIOPSKey = "diskIOPS"
 	// StorageDiskMBpsKey is maximum r/w throughput in MB/s
 	StorageDiskMBpsKey = "diskMBps"
+	// StorageDiskReadIOPSKey is maximum read I/O in operations per second
+	StorageDiskReadIOPSKey = "diskReadIOPS"
+	// StorageDiskWriteIOPSKey is maximum write I/O in operations per second
+	StorageDiskWriteIOPSKey = "diskWriteIOPS"
+	// StorageDiskReadMBpsKey is maximum read throughput in MB/s
+	StorageDiskReadMBpsKey = "diskReadMBps"
+	// StorageDiskWriteMBpsKey is maximum write throughput in MB/s
+	StorageDiskWriteMBpsKey = "diskWriteMBps"
+
+	// StorageDiskIOPSBurstKey is maximum unthrottled r/w I/O burst in operations per second
+	StorageDiskIOPSBurstKey = "diskIOPSBurst"
+	// StorageDiskReadIOPSBurstKey is maximum unthrottled read I/O burst in operations per second
+	StorageDiskReadIOPSBurstKey = "diskReadIOPSBurst"
+	// StorageDiskWriteIOPSBurstKey is maximum unthrottled write I/O burst in operations per second
+	StorageDiskWriteIOPSBurstKey = "diskWriteIOPSBurst"
+	// StorageDiskMBpsBurstKey is maximum unthrottled r/w throughput burst in MB/s
+	StorageDiskMBpsBurstKey = "diskMBpsBurst"
+	// StorageDiskReadMBpsBurstKey is maximum unthrottled read throughput burst in MB/s
+	StorageDiskReadMBpsBurstKey = "diskReadMBpsBurst"
+	// StorageDiskWriteMBpsBurstKey is maximum unthrottled write throughput burst in MB/s
+	StorageDiskWriteMBpsBurstKey = "diskWriteMBpsBurst"
+
+	// StorageDiskIOPSBurstLengthKey is maximum length of r/w I/O bursts in seconds
+	StorageDiskIOPSBurstLengthKey = "diskIOPSBurstLength"
+	// StorageDiskReadIOPSBurstLengthKey is maximum length of read I/O bursts in seconds
+	StorageDiskReadIOPSBurstLengthKey = "diskReadIOPSBurstLength"
+	// StorageDiskWriteIOPSBurstLengthKey is maximum length of write I/O bursts in seconds
+	StorageDiskWriteIOPSBurstLengthKey = "diskWriteIOPSBurstLength"
+	// StorageDiskMBpsBurstLengthKey is maximum length of r/w throughput bursts in seconds
+	StorageDiskMBpsBurstLengthKey = "diskMBpsBurstLength"
+	// StorageDiskReadMBpsBurstLengthKey is maximum length of read throughput bursts in seconds
+	StorageDiskReadMBpsBurstLengthKey = "diskReadMBpsBurstLength"
+	// StorageDiskWriteMBpsBurstLengthKey is maximum length of write throughput bursts in seconds
+	StorageDiskWriteMBpsBurstLengthKey = "diskWriteMBpsBurstLength"
 
 	// StorageBlockSizeKey the block size when formatting a volume
 	StorageBlockSizeKey = "blockSize"
@@ -52,6 +108,22 @@ const (
 
 	// EncryptionPassphraseKey is the encryption passphrase secret key
 	EncryptionPassphraseKey = "encryption-passphrase"
+	// CIFSPasswordKey is the cifs share password secret key
+	CIFSPasswordKey = "cifs-password"
+
+	// PVCNameKey is the PVC name, it is passed by the external-provisioner with --extra-create-metadata
+	PVCNameKey = "csi.storage.k8s.io/pvc/name"
+	// PVCNamespaceKey is the PVC namespace, it is passed by the external-provisioner with --extra-create-metadata
+	PVCNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	// PVNameKey is the PV name, it is passed by the external-provisioner with --extra-create-metadata
+	PVNameKey = "csi.storage.k8s.io/pv/name"
+
+	// MountSourceKey is the network share of the volume, it is set by the controller for nfs/cifs storages
+	MountSourceKey = "mountSource"
+	// MountFSTypeKey is the filesystem type of the network share
+	MountFSTypeKey = "mountFSType"
+	// MountOptionsKey is the mount options of the network share
+	MountOptionsKey = "mountOptions"
 )
 
 // constants for fstypes
@@ -60,4 +132,8 @@ const (
 	FSTypeExt4 = "ext4"
 	// FSTypeXfs represents the xfs filesystem type
 	FSTypeXfs = "xfs"
+	// FSTypeNFS represents the nfs filesystem type
+	FSTypeNFS = "nfs"
+	// FSTypeCIFS represents the cifs filesystem type
+	FSTypeCIFS = "cifs"
 )
@@ -0,0 +1,80 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+// errVolumeResizing is the error of the operations on the detached volume which is being resized through a temporary VM
+var errVolumeResizing = errors.New("volume is being resized")
+
+// errVolumeAttached is the error of the deletion of the volume which is attached to a VM
+var errVolumeAttached = errors.New("volume is attached")
+
+// errorCodes maps the errors of the Proxmox API to the gRPC codes.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{context.Canceled, codes.Canceled},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{proxmox.ErrClusterUnavailable, codes.Unavailable},
+	{proxmox.ErrNotFound, codes.NotFound},
+	{proxmox.ErrPermissionDenied, codes.PermissionDenied},
+	{proxmox.ErrStorageFull, codes.ResourceExhausted},
+	{proxmox.ErrLockTimeout, codes.Unavailable},
+	{proxmox.ErrVMLocked, codes.Aborted},
+	{proxmox.ErrInvalidParameter, codes.InvalidArgument},
+	{errVolumeResizing, codes.Aborted},
+	{errVolumeAttached, codes.FailedPrecondition},
+}
+
+// statusError returns the gRPC status error of the failed operation, the code is based on the error of the Proxmox API.
+func statusError(err error) error {
+	return status.Error(errorCode(err), err.Error())
+}
+
+// errorCode classifies the error, so the sidecars can tell the transient errors from the permanent ones.
+// The errors are often wrapped as strings, so the context errors are also matched by the message.
+func errorCode(err error) codes.Code {
+	err = proxmox.ParseError(err)
+
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	msg := err.Error()
+
+	switch {
+	case strings.Contains(msg, context.Canceled.Error()):
+		return codes.Canceled
+	case strings.Contains(msg, context.DeadlineExceeded.Error()):
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Internal
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg      string
+		err      error
+		expected codes.Code
+	}{
+		{
+			msg:      "Unknown",
+			err:      fmt.Errorf("failed to parse storage list"),
+			expected: codes.Internal,
+		},
+		{
+			msg:      "Canceled",
+			err:      fmt.Errorf("failed to get vm config: %v", context.Canceled),
+			expected: codes.Canceled,
+		},
+		{
+			msg:      "DeadlineExceeded",
+			err:      fmt.Errorf("failed to get vm config: %w", context.DeadlineExceeded),
+			expected: codes.DeadlineExceeded,
+		},
+		{
+			msg:      "CircuitBreaker",
+			err:      fmt.Errorf(`failed to get node list: Get "https://127.0.0.1:8006/api2/json/nodes": %v`, proxmox.ErrClusterUnavailable),
+			expected: codes.Unavailable,
+		},
+		{
+			msg:      "PermissionDenied",
+			err:      fmt.Errorf("failed to attach disk: 403 Permission check failed (/vms/100, VM.Config.Disk)"),
+			expected: codes.PermissionDenied,
+		},
+		{
+			msg:      "LockTimeout",
+			err:      fmt.Errorf("500 got timeout"),
+			expected: codes.Unavailable,
+		},
+		{
+			msg:      "DoesNotExist",
+			err:      fmt.Errorf("failed to get vm config: 500 Configuration file 'nodes/pve-1/qemu-server/100.conf' does not exist"),
+			expected: codes.NotFound,
+		},
+		{
+			msg:      "InternalServerError",
+			err:      fmt.Errorf("500 Internal Server Error"),
+			expected: codes.Internal,
+		},
+		{
+			msg:      "StorageFull",
+			err:      fmt.Errorf(`failed to create vm disk: 500 lvcreate 'pve/vm-9999-pvc-123' error: Volume group "pve" has insufficient free space`),
+			expected: codes.ResourceExhausted,
+		},
+		{
+			msg:      "VMLocked",
+			err:      fmt.Errorf("failed to attach disk: 500 VM is locked (backup)"),
+			expected: codes.Aborted,
+		},
+		{
+			msg:      "InvalidParameter",
+			err:      fmt.Errorf("400 Parameter verification failed."),
+			expected: codes.InvalidArgument,
+		},
+		{
+			msg:      "VMNotFound",
+			err:      fmt.Errorf("vm 'node-1' not found"),
+			expected: codes.NotFound,
+		},
+		{
+			msg:      "RegionNotFound",
+			err:      fmt.Errorf("proxmox cluster region-1 not found"),
+			expected: codes.Internal,
+		},
+		{
+			msg:      "NoConnection",
+			err:      fmt.Errorf("failed to get storage list: 596 Connection timed out"),
+			expected: codes.Unavailable,
+		},
+		{
+			msg:      "NetworkError",
+			err:      fmt.Errorf(`Get "https://127.0.0.1:8006/api2/json/nodes": dial tcp 127.0.0.1:8006: connect: connection refused`),
+			expected: codes.Unavailable,
+		},
+		{
+			msg:      "NotHTTPStatus",
+			err:      fmt.Errorf("failed to delete volume: vm-9999-pvc-403 is busy"),
+			expected: codes.Internal,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, errorCode(testCase.err))
+		})
+	}
+}
@@ -75,15 +75,9 @@ func locationFromTopologyRequirement(tr *proto.TopologyRequirement) (region, zon
 		}
 	}
 
+	// Requisite zones are only candidates, the best one is chosen by capacity
 	for _, top := range tr.GetRequisite() {
-		segment := top.GetSegments()
-
-		tsr := segment[corev1.LabelTopologyRegion]
-		tsz := segment[corev1.LabelTopologyZone]
-
-		if tsr != "" && tsz != "" {
-			return tsr, tsz
-		}
+		tsr := top.GetSegments()[corev1.LabelTopologyRegion]
 
 		if tsr != "" && region == "" {
 			region = tsr
@@ -93,6 +87,21 @@ func locationFromTopologyRequirement(tr *proto.TopologyRequirement) (region, zon
 	return region, ""
 }
 
+// zonesFromTopologyRequirement returns the requisite zones in the region.
+func zonesFromTopologyRequirement(tr *proto.TopologyRequirement, region string) []string {
+	zones := []string{}
+
+	for _, top := range tr.GetRequisite() {
+		segment := top.GetSegments()
+
+		if segment[corev1.LabelTopologyRegion] == region && segment[corev1.LabelTopologyZone] != "" {
+			zones = append(zones, segment[corev1.LabelTopologyZone])
+		}
+	}
+
+	return zones
+}
+
 func stripSecrets(msg interface{}) string {
 	reqValue := reflect.ValueOf(msg)
 	reqType := reqValue.Type()
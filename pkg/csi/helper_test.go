@@ -154,7 +154,7 @@ func TestLocationFromTopologyRequirement(t *testing.T) {
 				},
 			},
 			expectedRegion: "region1",
-			expectedZone:   "zone1",
+			expectedZone:   "",
 		},
 	}
 
@@ -171,3 +171,34 @@ func TestLocationFromTopologyRequirement(t *testing.T) {
 		})
 	}
 }
+
+func TestZonesFromTopologyRequirement(t *testing.T) {
+	t.Parallel()
+
+	topology := &proto.TopologyRequirement{
+		Requisite: []*proto.Topology{
+			{
+				Segments: map[string]string{
+					corev1.LabelTopologyRegion: "region1",
+					corev1.LabelTopologyZone:   "zone1",
+				},
+			},
+			{
+				Segments: map[string]string{
+					corev1.LabelTopologyRegion: "region2",
+					corev1.LabelTopologyZone:   "zone2",
+				},
+			},
+			{
+				Segments: map[string]string{
+					corev1.LabelTopologyRegion: "region1",
+					corev1.LabelTopologyZone:   "zone3",
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, []string{"zone1", "zone3"}, zonesFromTopologyRequirement(topology, "region1"))
+	assert.Equal(t, []string{}, zonesFromTopologyRequirement(topology, "region3"))
+	assert.Equal(t, []string{}, zonesFromTopologyRequirement(nil, "region1"))
+}
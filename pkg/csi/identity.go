@@ -26,7 +26,11 @@ import (
 )
 
 // IdentityService is the identity service for the CSI driver
-type IdentityService struct{}
+type IdentityService struct {
+	// HealthCheck returns the error of the plugin state, it is called by Probe.
+	// The plugin is not ready while it returns the error.
+	HealthCheck func() error
+}
 
 // NewIdentityService returns a new identity service
 func NewIdentityService() *IdentityService {
@@ -80,7 +84,17 @@ func (d *IdentityService) GetPluginCapabilities(context.Context, *csi.GetPluginC
 func (d *IdentityService) Probe(_ context.Context, _ *csi.ProbeRequest) (*csi.ProbeResponse, error) {
 	klog.V(5).Infof("Probe: called")
 
+	ready := true
+
+	if d.HealthCheck != nil {
+		if err := d.HealthCheck(); err != nil {
+			klog.Warningf("Probe: %v, the plugin is not ready", err)
+
+			ready = false
+		}
+	}
+
 	return &csi.ProbeResponse{
-		Ready: &wrappers.BoolValue{Value: true},
+		Ready: &wrappers.BoolValue{Value: ready},
 	}, nil
 }
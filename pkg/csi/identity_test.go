@@ -18,6 +18,7 @@ package csi_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	proto "github.com/container-storage-interface/spec/lib/go/csi"
@@ -85,3 +86,18 @@ func TestProbe(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, resp)
 }
+
+func TestProbeHealthCheckError(t *testing.T) {
+	env := newIdentityServerTestEnv()
+	env.service.HealthCheck = func() error { return fmt.Errorf("failed to reload cloud config") }
+
+	resp, err := env.service.Probe(context.Background(), &proto.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.False(t, resp.GetReady().GetValue())
+
+	env.service.HealthCheck = func() error { return nil }
+
+	resp, err = env.service.Probe(context.Background(), &proto.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.True(t, resp.GetReady().GetValue())
+}
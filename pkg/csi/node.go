@@ -60,6 +60,9 @@ var volumeCaps = []csi.VolumeCapability_AccessMode{
 	{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
 	},
+	{
+		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	},
 }
 
 // NodeService is the node service for the CSI driver
@@ -105,6 +108,10 @@ func (n *NodeService) NodeStageVolume(_ context.Context, request *csi.NodeStageV
 		volumeContext = map[string]string{}
 	}
 
+	if source := volumeContext[MountSourceKey]; source != "" {
+		return n.nodeStageNetworkVolume(request, source)
+	}
+
 	devicePath := request.GetPublishContext()["DevicePath"]
 	if len(devicePath) == 0 {
 		klog.Errorf("NodePublishVolume: DevicePath must be provided")
@@ -118,6 +125,11 @@ func (n *NodeService) NodeStageVolume(_ context.Context, request *csi.NodeStageV
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
+	// Shared disks must never be formatted by the node, it is the job of a clustered filesystem
+	if isMultiNodeVolumeCapability(volumeCapability) {
+		return nil, status.Error(codes.InvalidArgument, "multi-node access mode is supported only for raw block volumes")
+	}
+
 	notMnt, err := m.IsLikelyNotMountPointAttach(stagingTarget)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -204,6 +216,51 @@ func (n *NodeService) NodeStageVolume(_ context.Context, request *csi.NodeStageV
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+func (n *NodeService) nodeStageNetworkVolume(request *csi.NodeStageVolumeRequest, source string) (*csi.NodeStageVolumeResponse, error) {
+	stagingTarget := request.GetStagingTargetPath()
+	volumeContext := request.GetVolumeContext()
+
+	if request.GetVolumeCapability().GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "raw block volumes are not supported on network storage")
+	}
+
+	notMnt, err := n.Mount.IsLikelyNotMountPointAttach(stagingTarget)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if !notMnt {
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	fsType := volumeContext[MountFSTypeKey]
+
+	var (
+		options          []string
+		sensitiveOptions []string
+	)
+
+	if opts := volumeContext[MountOptionsKey]; opts != "" {
+		options = append(options, strings.Split(opts, ",")...)
+	}
+
+	if mnt := request.GetVolumeCapability().GetMount(); mnt != nil {
+		options = append(options, mnt.GetMountFlags()...)
+	}
+
+	if password, ok := request.GetSecrets()[CIFSPasswordKey]; ok && fsType == FSTypeCIFS {
+		sensitiveOptions = append(sensitiveOptions, "password="+password)
+	}
+
+	if err = n.Mount.Mounter().MountSensitive(source, stagingTarget, fsType, options, sensitiveOptions); err != nil {
+		klog.Errorf("NodeStageVolume: failed to mount %s at %s (fstype: %s), error: %v", source, stagingTarget, fsType, err)
+
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
 // NodeUnstageVolume is called by the CO when a workload that was using the specified volume is being moved to a different node.
 //
 //nolint:dupl
@@ -273,14 +330,16 @@ func (n *NodeService) NodePublishVolume(_ context.Context, request *csi.NodePubl
 		return nil, status.Error(codes.InvalidArgument, "VolumeCapability must be provided")
 	}
 
-	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}) {
+	networkVolume := request.GetVolumeContext()[MountSourceKey] != ""
+
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volumeCapability}, networkVolume) {
 		klog.Errorf("NodePublishVolume: VolumeCapability not supported")
 
 		return nil, status.Error(codes.InvalidArgument, "VolumeCapability not supported")
 	}
 
 	devicePath := request.GetPublishContext()["DevicePath"]
-	if len(devicePath) == 0 {
+	if len(devicePath) == 0 && !networkVolume {
 		klog.Errorf("NodePublishVolume: DevicePath must be provided")
 
 		return nil, status.Error(codes.InvalidArgument, "DevicePath must be provided")
@@ -545,8 +604,16 @@ func (n *NodeService) NodeGetInfo(ctx context.Context, request *csi.NodeGetInfoR
 	}, nil
 }
 
-func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
+func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability, networkVolume bool) bool {
 	hasSupport := func(reqcap *csi.VolumeCapability) bool {
+		if networkVolume {
+			if reqcap.GetBlock() != nil {
+				return false
+			}
+		} else if isMultiNodeVolumeCapability(reqcap) && reqcap.GetBlock() == nil {
+			return false
+		}
+
 		for _, c := range volumeCaps {
 			if c.GetMode() == reqcap.AccessMode.GetMode() {
 				return true
@@ -567,6 +634,17 @@ func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
 	return foundAll
 }
 
+func isMultiNodeVolumeCapability(volCap *csi.VolumeCapability) bool {
+	switch volCap.GetAccessMode().GetMode() { //nolint:exhaustive
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:
+		return true
+	}
+
+	return false
+}
+
 func collectMountOptions(fsType string, mntFlags []string) []string {
 	var options []string
 	options = append(options, mntFlags...)
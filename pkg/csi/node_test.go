@@ -112,7 +112,21 @@ func TestNodeStageVolumeErrors(t *testing.T) {
 			},
 			expectedError: nil,
 		},
-
+		{
+			msg: "MultiNodeFilesystem",
+			request: &proto.NodeStageVolumeRequest{
+				VolumeId:          "pvc-1",
+				StagingTargetPath: "/staging",
+				VolumeCapability: &proto.VolumeCapability{
+					AccessMode: &proto.VolumeCapability_AccessMode{
+						Mode: proto.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+					AccessType: volcap.AccessType,
+				},
+				PublishContext: params,
+			},
+			expectedError: fmt.Errorf("multi-node access mode is supported only for raw block volumes"),
+		},
 		{
 			msg: "DevicePath",
 			request: &proto.NodeStageVolumeRequest{
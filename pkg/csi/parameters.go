@@ -0,0 +1,304 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageParameterPrefixes are the prefixes of the parameters added by the Kubernetes sidecars
+var storageParameterPrefixes = []string{
+	"csi.storage.k8s.io/",
+	"storage.kubernetes.io/",
+}
+
+// storageParameters is the typed StorageClass parameters, they are also stored in the volume context.
+type storageParameters struct {
+	Storages         []string
+	StorageSelector  map[string]string
+	Format           string
+	DiskNameTemplate string
+	Shared           bool
+	ZoneWeights      map[string]float64
+	OvercommitRatio  float64
+
+	BlockSize int
+	InodeSize int
+
+	Cache     string
+	SSD       bool
+	AIO       string
+	IOThread  *bool
+	Backup    *bool
+	Replicate *bool
+	DiskQoS   map[string]int
+}
+
+// parseStorageParameters parses and validates the StorageClass parameters, it returns InvalidArgument errors.
+// If strict is set, unknown parameters are rejected.
+// The volume context of existing volumes may have parameters of older versions, so it is parsed in non-strict mode.
+//
+//nolint:gocyclo,cyclop
+func parseStorageParameters(params map[string]string, strict bool) (*storageParameters, error) {
+	if strict {
+		for key := range params {
+			if !isStorageParameterKnown(key) {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameters %s is unknown", key)
+			}
+		}
+	}
+
+	p := &storageParameters{
+		Storages: parseStorageList(params[StorageIDKey]),
+		Format:   params[StorageFormatKey],
+		Cache:    params[StorageCacheKey],
+		AIO:      params[StorageAIOKey],
+		DiskQoS:  map[string]int{},
+	}
+
+	if params[StorageSelectorKey] != "" {
+		if params[StorageIDKey] != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s and %s are mutually exclusive", StorageIDKey, StorageSelectorKey)
+		}
+
+		selector, err := parseStorageSelector(params[StorageSelectorKey])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s: %v", StorageSelectorKey, err)
+		}
+
+		p.StorageSelector = selector
+	}
+
+	var err error
+
+	for key, value := range map[string]*int{StorageBlockSizeKey: &p.BlockSize, StorageInodeSizeKey: &p.InodeSize} {
+		if params[key] != "" {
+			if *value, err = strconv.Atoi(params[key]); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be a number", key)
+			}
+		}
+	}
+
+	switch p.Cache {
+	case "", "directsync", "none", "writeback", "writethrough":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be directsync, none, writeback or writethrough", StorageCacheKey)
+	}
+
+	switch p.AIO {
+	case "", "io_uring", "threads":
+	case "native":
+		// QEMU requires direct I/O for native aio
+		if p.Cache != "" && p.Cache != "none" && p.Cache != "directsync" {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be none or directsync for native aio", StorageCacheKey)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be io_uring, native or threads", StorageAIOKey)
+	}
+
+	switch p.Format {
+	case "", "raw", "qcow2", "vmdk":
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be raw, qcow2 or vmdk", StorageFormatKey)
+	}
+
+	if params[StorageDiskNameTemplateKey] != "" {
+		if err = checkDiskNameTemplate(params[StorageDiskNameTemplateKey]); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s: %v", StorageDiskNameTemplateKey, err)
+		}
+
+		p.DiskNameTemplate = params[StorageDiskNameTemplateKey]
+	}
+
+	flags := map[string]**bool{
+		StorageIOThreadKey:  &p.IOThread,
+		StorageReplicateKey: &p.Replicate,
+		StorageBackupKey:    &p.Backup,
+	}
+
+	for _, key := range []string{StorageSSDKey, StorageSharedKey, StorageIOThreadKey, StorageReplicateKey, StorageBackupKey} {
+		if params[key] == "" {
+			continue
+		}
+
+		if params[key] != "true" && params[key] != "false" {
+			return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be true or false", key)
+		}
+
+		value := params[key] == "true"
+
+		switch key {
+		case StorageSSDKey:
+			p.SSD = value
+		case StorageSharedKey:
+			p.Shared = value
+		default:
+			*flags[key] = &value
+		}
+	}
+
+	if p.Shared && p.Cache != "" && p.Cache != "none" && p.Cache != "directsync" {
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be none or directsync for shared volumes", StorageCacheKey)
+	}
+
+	for key := range diskQoSOptions {
+		if params[key] != "" {
+			v, err := strconv.Atoi(params[key])
+			if err != nil || v <= 0 {
+				return nil, status.Errorf(codes.InvalidArgument, "Parameters %s must be a positive number", key)
+			}
+
+			p.DiskQoS[key] = v
+		}
+	}
+
+	if err = checkDiskQoS(p.DiskQoS); err != nil {
+		return nil, err
+	}
+
+	if p.ZoneWeights, err = parseZoneWeights(params[StorageZoneWeightsKey]); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s: %v", StorageZoneWeightsKey, err)
+	}
+
+	if p.OvercommitRatio, err = parseOvercommitRatio(params[StorageOvercommitRatioKey]); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Parameters %s: %v", StorageOvercommitRatioKey, err)
+	}
+
+	return p, nil
+}
+
+// checkDiskQoS checks the combinations of the QoS parameters which are rejected by Proxmox and QEMU:
+// the total limit with the read or write limit, the burst without the limit or lower than the limit,
+// and the burst length without the burst.
+func checkDiskQoS(qos map[string]int) error {
+	options := map[string]int{}
+	keys := map[string]string{}
+
+	for key, option := range diskQoSOptions {
+		keys[option] = key
+
+		if value, ok := qos[key]; ok {
+			options[option] = value
+		}
+	}
+
+	for _, limit := range []string{"iops", "mbps"} {
+		for _, op := range []string{"_rd", "_wr"} {
+			if _, ok := options[limit]; ok {
+				if _, ok := options[limit+op]; ok {
+					return status.Errorf(codes.InvalidArgument, "Parameters %s and %s are mutually exclusive", keys[limit], keys[limit+op])
+				}
+			}
+		}
+	}
+
+	for _, limit := range []string{"iops", "iops_rd", "iops_wr", "mbps", "mbps_rd", "mbps_wr"} {
+		burst := limit + "_max"
+		// The burst length of the bandwidth limits is bps_max_length, not mbps_max_length
+		length := strings.TrimPrefix(burst, "m") + "_length"
+
+		if _, ok := options[length]; ok {
+			if _, ok := options[burst]; !ok {
+				return status.Errorf(codes.InvalidArgument, "Parameters %s requires %s", keys[length], keys[burst])
+			}
+		}
+
+		if value, ok := options[burst]; ok {
+			base, ok := options[limit]
+			if !ok {
+				return status.Errorf(codes.InvalidArgument, "Parameters %s requires %s", keys[burst], keys[limit])
+			}
+
+			if value < base {
+				return status.Errorf(codes.InvalidArgument, "Parameters %s must be greater than or equal to %s", keys[burst], keys[limit])
+			}
+		}
+	}
+
+	return nil
+}
+
+// isStorageParameterKnown returns true if the key is a StorageClass parameter.
+// The mount keys are set by the controller for the network storages, they are not allowed in the StorageClass.
+func isStorageParameterKnown(key string) bool {
+	switch key {
+	case StorageIDKey, StorageSelectorKey, StorageFormatKey, StorageDiskNameTemplateKey, StorageSharedKey,
+		StorageZoneWeightsKey, StorageOvercommitRatioKey,
+		StorageBlockSizeKey, StorageInodeSizeKey,
+		StorageCacheKey, StorageSSDKey, StorageAIOKey,
+		StorageIOThreadKey, StorageReplicateKey, StorageBackupKey:
+		return true
+	}
+
+	if _, ok := diskQoSOptions[key]; ok {
+		return true
+	}
+
+	for _, prefix := range storageParameterPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diskOptions returns the Proxmox disk options of the volume.
+func (p *storageParameters) diskOptions() map[string]string {
+	options := map[string]string{
+		"backup":   "0",
+		"iothread": "1",
+	}
+
+	if p.SSD {
+		options["ssd"] = "1"
+		options["discard"] = "on"
+	}
+
+	if p.Cache != "" {
+		options["cache"] = p.Cache
+	}
+
+	if p.AIO != "" {
+		options["aio"] = p.AIO
+	}
+
+	if p.IOThread != nil && !*p.IOThread {
+		options["iothread"] = "0"
+	}
+
+	if p.Backup != nil && *p.Backup {
+		options["backup"] = "1"
+	}
+
+	if p.Replicate != nil {
+		options["replicate"] = "0"
+		if *p.Replicate {
+			options["replicate"] = "1"
+		}
+	}
+
+	for key, v := range p.DiskQoS {
+		options[diskQoSOptions[key]] = strconv.Itoa(v)
+	}
+
+	return options
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStorageParameters(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg             string
+		params          map[string]string
+		strict          bool
+		expectedOptions map[string]string
+		expectedError   string
+	}{
+		{
+			msg:    "Default",
+			params: map[string]string{StorageIDKey: "local-lvm"},
+			strict: true,
+			expectedOptions: map[string]string{
+				"backup":   "0",
+				"iothread": "1",
+			},
+		},
+		{
+			msg: "DiskOptions",
+			params: map[string]string{
+				StorageIDKey:                      "local-lvm",
+				StorageSSDKey:                     "true",
+				StorageCacheKey:                   "none",
+				StorageAIOKey:                     "native",
+				StorageIOThreadKey:                "false",
+				StorageBackupKey:                  "true",
+				StorageReplicateKey:               "false",
+				StorageDiskIOPSKey:                "1000",
+				StorageDiskReadMBpsKey:            "100",
+				StorageDiskReadMBpsBurstKey:       "500",
+				StorageDiskReadMBpsBurstLengthKey: "60",
+				"csi.storage.k8s.io/pvc/name":     "pvc",
+				"storage.kubernetes.io/something": "value",
+			},
+			strict: true,
+			expectedOptions: map[string]string{
+				"backup":            "1",
+				"iothread":          "0",
+				"replicate":         "0",
+				"ssd":               "1",
+				"discard":           "on",
+				"cache":             "none",
+				"aio":               "native",
+				"iops":              "1000",
+				"mbps_rd":           "100",
+				"mbps_rd_max":       "500",
+				"bps_rd_max_length": "60",
+			},
+		},
+		{
+			msg:           "UnknownStrict",
+			params:        map[string]string{StorageIDKey: "local-lvm", "fstype": "ext4"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters fstype is unknown",
+		},
+		{
+			msg:           "MountSourceStrict",
+			params:        map[string]string{StorageIDKey: "nfs", MountSourceKey: "10.0.0.1:/export/images/vm-9999-pvc-123.raw"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters mountSource is unknown",
+		},
+		{
+			msg:           "DiskQoSZero",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskIOPSKey: "0"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskIOPS must be a positive number",
+		},
+		{
+			msg:           "DiskQoSTotalAndRead",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskMBpsKey: "100", StorageDiskReadMBpsKey: "50"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskMBps and diskReadMBps are mutually exclusive",
+		},
+		{
+			msg:           "DiskQoSTotalAndWrite",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskIOPSKey: "1000", StorageDiskWriteIOPSKey: "500"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskIOPS and diskWriteIOPS are mutually exclusive",
+		},
+		{
+			msg:           "DiskQoSBurstWithoutLimit",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskIOPSKey: "1000", StorageDiskReadMBpsBurstKey: "500"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskReadMBpsBurst requires diskReadMBps",
+		},
+		{
+			msg:           "DiskQoSBurstLowerThanLimit",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskWriteIOPSKey: "1000", StorageDiskWriteIOPSBurstKey: "500"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskWriteIOPSBurst must be greater than or equal to diskWriteIOPS",
+		},
+		{
+			msg:           "DiskQoSBurstLengthWithoutBurst",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskMBpsKey: "100", StorageDiskMBpsBurstLengthKey: "60"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskMBpsBurstLength requires diskMBpsBurst",
+		},
+		{
+			msg:           "DiskQoSIOPSBurstLengthWithoutBurst",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageDiskReadIOPSKey: "1000", StorageDiskReadIOPSBurstLengthKey: "60"},
+			strict:        true,
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters diskReadIOPSBurstLength requires diskReadIOPSBurst",
+		},
+		{
+			msg:    "UnknownVolumeContext",
+			params: map[string]string{StorageIDKey: "local-lvm", "fstype": "ext4"},
+			expectedOptions: map[string]string{
+				"backup":   "0",
+				"iothread": "1",
+			},
+		},
+		{
+			msg:           "SSD",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageSSDKey: "1"},
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters ssd must be true or false",
+		},
+		{
+			msg:           "SharedCache",
+			params:        map[string]string{StorageIDKey: "rbd", StorageSharedKey: "true", StorageCacheKey: "writeback"},
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters cache must be none or directsync for shared volumes",
+		},
+		{
+			msg:           "BlockSize",
+			params:        map[string]string{StorageIDKey: "local-lvm", StorageBlockSizeKey: "4k"},
+			expectedError: "rpc error: code = InvalidArgument desc = Parameters blockSize must be a number",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			p, err := parseStorageParameters(testCase.params, testCase.strict)
+
+			if testCase.expectedError != "" {
+				assert.NotNil(t, err)
+				assert.Equal(t, testCase.expectedError, err.Error())
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, testCase.expectedOptions, p.diskOptions())
+			}
+		})
+	}
+}
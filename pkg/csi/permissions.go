@@ -0,0 +1,209 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+// requiredPrivilege is the Proxmox privilege the plugin needs and the operation which uses it.
+type requiredPrivilege struct {
+	name     string
+	usedFor  string
+	optional bool
+}
+
+var (
+	vmPrivileges = []requiredPrivilege{
+		{name: "VM.Audit", usedFor: "find the VMs of the Kubernetes nodes"},
+		{name: "VM.Config.Disk", usedFor: "attach and detach the volumes"},
+		{name: "VM.Allocate", usedFor: "expand the detached volumes and keep the deleted volumes in the trash", optional: true},
+	}
+
+	nodePrivileges = []requiredPrivilege{
+		// The node list is readable without it, but the node status is not
+		{name: "Sys.Audit", usedFor: "read the node status", optional: true},
+	}
+
+	storagePrivileges = []requiredPrivilege{
+		{name: "Datastore.Audit", usedFor: "list the volumes and the storage capacity"},
+		{name: "Datastore.AllocateSpace", usedFor: "create the volumes"},
+		{name: "Datastore.Allocate", usedFor: "delete and resize the volumes"},
+	}
+)
+
+// PrivilegeCheck is the result of the check of one privilege on the ACL path.
+type PrivilegeCheck struct {
+	Path      string `json:"path"`
+	Privilege string `json:"privilege"`
+	UsedFor   string `json:"usedFor"`
+	Granted   bool   `json:"granted"`
+	// Optional privileges are needed only by some features, the plugin works without them
+	Optional bool `json:"optional,omitempty"`
+}
+
+// PermissionReport is the result of the privilege checks of one region.
+type PermissionReport struct {
+	Region string           `json:"region"`
+	Checks []PrivilegeCheck `json:"checks,omitempty"`
+	// Error is set if the privileges of the region could not be checked
+	Error string `json:"error,omitempty"`
+}
+
+// OK returns true if all required privileges are granted.
+func (r *PermissionReport) OK() bool {
+	if r.Error != "" {
+		return false
+	}
+
+	for _, c := range r.Checks {
+		if !c.Granted && !c.Optional {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckPermissions checks the privileges of the token or the user of each region.
+// The privileges are checked on the given storages, or on all storages with the disk images if none are given.
+func (d *ControllerService) CheckPermissions(ctx context.Context, storages []string) []PermissionReport {
+	reports := []PermissionReport{}
+
+	for _, region := range d.Cluster.Regions() {
+		report := PermissionReport{Region: region}
+
+		cl, err := d.Cluster.GetProxmoxCluster(ctx, region)
+		if err == nil {
+			report.Checks, err = checkRegionPermissions(ctx, cl, storages)
+		}
+
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+func checkRegionPermissions(ctx context.Context, cl *pxapi.Client, storages []string) ([]PrivilegeCheck, error) {
+	if len(storages) == 0 {
+		var err error
+
+		if storages, err = listImageStorages(cl); err != nil {
+			return nil, err
+		}
+	}
+
+	paths := map[string][]requiredPrivilege{
+		"/vms":   vmPrivileges,
+		"/nodes": nodePrivileges,
+	}
+	order := []string{"/vms", "/nodes"}
+
+	for _, storageName := range storages {
+		path := "/storage/" + storageName
+		paths[path] = storagePrivileges
+		order = append(order, path)
+	}
+
+	if len(storages) == 0 {
+		// No storage is visible without Datastore.Audit
+		paths["/storage"] = storagePrivileges
+		order = append(order, "/storage")
+	}
+
+	checks := []PrivilegeCheck{}
+
+	for _, path := range order {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		granted, err := getPermissions(cl, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range paths[path] {
+			checks = append(checks, PrivilegeCheck{
+				Path:      path,
+				Privilege: p.name,
+				UsedFor:   p.usedFor,
+				Granted:   granted[p.name],
+				Optional:  p.optional,
+			})
+		}
+	}
+
+	return checks, nil
+}
+
+// listImageStorages returns the names of the storages with the disk images.
+func listImageStorages(cl *pxapi.Client) ([]string, error) {
+	resources, err := cl.GetResourceList("storage")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage list: %v", err)
+	}
+
+	storages := []string{}
+
+	for _, item := range resources {
+		storage, ok := item.(map[string]interface{})
+		if !ok || storage["type"] != "storage" {
+			continue
+		}
+
+		storageName, _ := storage["storage"].(string) //nolint:errcheck
+		content, _ := storage["content"].(string)     //nolint:errcheck
+
+		if slices.Contains(strings.Split(content, ","), "images") && !slices.Contains(storages, storageName) {
+			storages = append(storages, storageName)
+		}
+	}
+
+	slices.Sort(storages)
+
+	return storages, nil
+}
+
+// getPermissions returns the privileges of the current token or user on the ACL path, the inherited ones included.
+func getPermissions(cl *pxapi.Client, path string) (map[string]bool, error) {
+	data, err := cl.GetItemList("/access/permissions?path=" + url.QueryEscape(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get permissions on %s: %v", path, err)
+	}
+
+	granted := map[string]bool{}
+
+	paths, _ := data["data"].(map[string]interface{}) //nolint:errcheck
+	if privileges, ok := paths[path].(map[string]interface{}); ok {
+		for name := range privileges {
+			granted[name] = true
+		}
+	}
+
+	return granted, nil
+}
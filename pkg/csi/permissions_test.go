@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi_test
+
+import (
+	"context"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func (ts *csiTestSuite) TestCheckPermissions() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	permissions := map[string]map[string]interface{}{
+		"/vms":               {"VM.Audit": 1, "VM.Config.Disk": 1},
+		"/nodes":             {"Sys.Audit": 1},
+		"/storage/local-lvm": {"Datastore.Audit": 1, "Datastore.Allocate": 1, "Datastore.AllocateSpace": 1},
+		"/storage/rbd":       {"Datastore.Audit": 1},
+	}
+
+	for path, privileges := range permissions {
+		httpmock.RegisterResponderWithQuery("GET", "https://127.0.0.1:8006/api2/json/access/permissions", map[string]string{"path": path},
+			httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": map[string]interface{}{path: privileges}}))
+	}
+
+	reports := ts.s.CheckPermissions(context.Background(), nil)
+	ts.Require().Len(reports, 2)
+
+	ts.Require().Equal("cluster-1", reports[0].Region)
+	ts.Require().Empty(reports[0].Error)
+	ts.Require().False(reports[0].OK())
+
+	missing := []string{}
+
+	for _, c := range reports[0].Checks {
+		if !c.Granted {
+			missing = append(missing, c.Path+" "+c.Privilege)
+		}
+	}
+
+	ts.Require().Equal([]string{
+		"/vms VM.Allocate",
+		"/storage/rbd Datastore.AllocateSpace",
+		"/storage/rbd Datastore.Allocate",
+	}, missing)
+
+	// The storage list of cluster-2 is empty, the permissions are not readable
+	ts.Require().Equal("cluster-2", reports[1].Region)
+	ts.Require().Contains(reports[1].Error, "failed to get permissions on /vms")
+
+	// Only the optional privilege is missing on local-lvm
+	reports = ts.s.CheckPermissions(context.Background(), []string{"local-lvm"})
+	ts.Require().True(reports[0].OK())
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultConfigReloadInterval is the default period of the cloud config checks
+	DefaultConfigReloadInterval = 30 * time.Second
+)
+
+// cloudConfigVar is the number of the failed reloads and the error of the last reload, it is published as cloud_config in expvar.
+var cloudConfigVar = expvar.NewMap("cloud_config")
+
+// ReloadCloudConfig reads the cloud config and the secret files again, and applies the config if it is changed.
+// The previous config is kept if the new one is invalid, the plugin keeps working with it,
+// so the error is only logged and published in the cloud_config metric.
+func (d *ControllerService) ReloadCloudConfig() error {
+	d.configLock.Lock()
+	defer d.configLock.Unlock()
+
+	if d.cloudConfig == "" {
+		return nil
+	}
+
+	cfg, err := proxmox.ReadCloudConfigFromFile(d.cloudConfig)
+	if err == nil && !reflect.DeepEqual(cfg, d.config) {
+		if err = d.Cluster.Update(&cfg); err == nil {
+			d.config = cfg
+
+			klog.Infof("cloud config %s is reloaded, regions: %s", d.cloudConfig, strings.Join(d.Cluster.Regions(), ", "))
+		}
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to reload cloud config %s: %v", d.cloudConfig, err)
+
+		// The config is checked periodically, the same error is logged once
+		if d.reloadErr == nil || d.reloadErr.Error() != err.Error() {
+			klog.Errorf("%v, the previous config is used", err)
+		}
+
+		cloudConfigVar.Add("reload_errors", 1)
+	} else if d.reloadErr != nil {
+		klog.Infof("cloud config %s is valid again", d.cloudConfig)
+	}
+
+	lastError := new(expvar.String)
+	if err != nil {
+		lastError.Set(err.Error())
+	}
+
+	cloudConfigVar.Set("last_error", lastError)
+
+	d.reloadErr = err
+
+	return err
+}
+
+// WatchCloudConfig reloads the cloud config periodically until the context is done.
+// Kubernetes updates the mounted secrets by replacing the symlinks, so the files are polled instead of watched.
+func (d *ControllerService) WatchCloudConfig(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.ReloadCloudConfig() //nolint:errcheck
+		}
+	}
+}
+
+// CloudConfigStatus returns the error of the last reload of the cloud config, or nil if the config is applied.
+func (d *ControllerService) CloudConfigStatus() error {
+	d.configLock.Lock()
+	defer d.configLock.Unlock()
+
+	return d.reloadErr
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi_test
+
+import (
+	"expvar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+)
+
+func TestReloadCloudConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	secretFile := filepath.Join(dir, "token_secret")
+
+	writeFile := func(name, data string) {
+		assert.Nil(t, os.WriteFile(name, []byte(data), 0o600))
+	}
+
+	writeFile(secretFile, "secret")
+	writeFile(configFile, `
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    token_id: "user!token-id"
+    token_secret_file: `+secretFile+`
+    region: cluster-1
+`)
+
+	service, err := csi.NewControllerService(configFile)
+	assert.Nil(t, err)
+	assert.Nil(t, service.ReloadCloudConfig())
+	assert.Equal(t, []string{"cluster-1"}, service.Cluster.Regions())
+
+	// The rotated secret and the new region are applied
+	writeFile(secretFile, "rotated")
+	writeFile(configFile, `
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    token_id: "user!token-id"
+    token_secret_file: `+secretFile+`
+    region: cluster-1
+  - url: https://127.0.0.2:8006/api2/json
+    token_id: "user!token-id"
+    token_secret_file: `+secretFile+`
+    region: cluster-2
+`)
+
+	assert.Nil(t, service.ReloadCloudConfig())
+	assert.Nil(t, service.CloudConfigStatus())
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, service.Cluster.Regions())
+
+	// The invalid config is not applied
+	writeFile(configFile, `
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    region: cluster-1
+`)
+
+	assert.NotNil(t, service.ReloadCloudConfig())
+	assert.NotNil(t, service.CloudConfigStatus())
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, service.Cluster.Regions())
+
+	// The error is published in the metrics
+	metric, ok := expvar.Get("cloud_config").(*expvar.Map)
+	assert.True(t, ok)
+	assert.Contains(t, metric.Get("last_error").String(), "failed to reload cloud config")
+}
@@ -0,0 +1,470 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/volume"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientkubernetes "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TrashConfigMapKey is the key of the trash index in the ConfigMap
+	TrashConfigMapKey = "volumes"
+	// TrashAccessModesConfigMapKey is the key of the access modes of the PVs of the deleted volumes in the ConfigMap
+	TrashAccessModesConfigMapKey = "accessModes"
+
+	// trashVMPrefix is the name prefix of the stopped VMs which hold the volumes in the trash
+	trashVMPrefix = "csi-trash"
+	// trashVMMarker is in the description of the trash VMs
+	trashVMMarker = "is deleted by " + DriverName
+)
+
+// VolumeTrash keeps the deleted volumes until the retention period expires.
+// Proxmox cannot rename a disk which is not attached to a VM, so the disk keeps its name,
+// and it is attached to the stopped VM csi-trash-<vmid> to mark it as deleted on the Proxmox side.
+// The trash records the deletion time of the volumes.
+type VolumeTrash interface {
+	// Add adds the volume to the trash, the deletion time of the volume already in the trash is not changed
+	Add(ctx context.Context, volumeID string, deletedAt time.Time) error
+	// Remove removes the volume from the trash
+	Remove(ctx context.Context, volumeID string) error
+	// List returns the volumes in the trash with the deletion time
+	List(ctx context.Context) (map[string]time.Time, error)
+	// AccessModes returns the access modes of the PV of the volume in the trash, or nil if they are unknown
+	AccessModes(ctx context.Context, volumeID string) ([]corev1.PersistentVolumeAccessMode, error)
+}
+
+// trashIndex is the content of the trash ConfigMap.
+type trashIndex struct {
+	volumes     map[string]time.Time
+	accessModes map[string][]corev1.PersistentVolumeAccessMode
+}
+
+type configMapTrash struct {
+	clientset clientkubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapTrash returns the volume trash which stores the index in the ConfigMap.
+func NewConfigMapTrash(clientset clientkubernetes.Interface, namespace, name string) VolumeTrash {
+	return &configMapTrash{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (t *configMapTrash) Add(ctx context.Context, volumeID string, deletedAt time.Time) error {
+	// The PV is deleted by the provisioner after the volume, so it still has the access modes
+	accessModes, err := t.getAccessModes(ctx, volumeID)
+	if err != nil {
+		klog.Warningf("failed to get the access modes of volume %s: %v", volumeID, err)
+	}
+
+	return t.update(ctx, func(index *trashIndex) bool {
+		if _, ok := index.volumes[volumeID]; ok {
+			return false
+		}
+
+		index.volumes[volumeID] = deletedAt.UTC().Truncate(time.Second)
+
+		if len(accessModes) > 0 {
+			index.accessModes[volumeID] = accessModes
+		}
+
+		return true
+	})
+}
+
+func (t *configMapTrash) Remove(ctx context.Context, volumeID string) error {
+	return t.update(ctx, func(index *trashIndex) bool {
+		if _, ok := index.volumes[volumeID]; !ok {
+			return false
+		}
+
+		delete(index.volumes, volumeID)
+		delete(index.accessModes, volumeID)
+
+		return true
+	})
+}
+
+func (t *configMapTrash) List(ctx context.Context) (map[string]time.Time, error) {
+	index, err := t.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.volumes, nil
+}
+
+func (t *configMapTrash) AccessModes(ctx context.Context, volumeID string) ([]corev1.PersistentVolumeAccessMode, error) {
+	index, err := t.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.accessModes[volumeID], nil
+}
+
+func (t *configMapTrash) get(ctx context.Context) (*trashIndex, error) {
+	cm, err := t.clientset.CoreV1().ConfigMaps(t.namespace).Get(ctx, t.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return parseTrashConfigMap(&corev1.ConfigMap{})
+		}
+
+		return nil, fmt.Errorf("failed to get trash configmap: %v", err)
+	}
+
+	return parseTrashConfigMap(cm)
+}
+
+// getAccessModes returns the access modes of the PV of the volume, or nil if there is no PV.
+func (t *configMapTrash) getAccessModes(ctx context.Context, volumeID string) ([]corev1.PersistentVolumeAccessMode, error) {
+	pvs, err := t.clientset.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == DriverName && pv.Spec.CSI.VolumeHandle == volumeID {
+			return pv.Spec.AccessModes, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (t *configMapTrash) update(ctx context.Context, mutate func(*trashIndex) bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cms := t.clientset.CoreV1().ConfigMaps(t.namespace)
+
+		cm, err := cms.Get(ctx, t.name, metav1.GetOptions{})
+		exist := err == nil
+
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to get trash configmap: %v", err)
+			}
+
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      t.name,
+					Namespace: t.namespace,
+				},
+			}
+		}
+
+		index, err := parseTrashConfigMap(cm)
+		if err != nil {
+			return err
+		}
+
+		if !mutate(index) {
+			return nil
+		}
+
+		cm.Data = map[string]string{}
+
+		for key, value := range map[string]interface{}{TrashConfigMapKey: index.volumes, TrashAccessModesConfigMapKey: index.accessModes} {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("failed to encode trash: %v", err)
+			}
+
+			cm.Data[key] = string(data)
+		}
+
+		if !exist {
+			_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Another replica created it, retry with the new version
+				return apierrors.NewConflict(corev1.Resource("configmaps"), t.name, err)
+			}
+		} else {
+			_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+		}
+
+		return err
+	})
+}
+
+func parseTrashConfigMap(cm *corev1.ConfigMap) (*trashIndex, error) {
+	index := &trashIndex{
+		volumes:     map[string]time.Time{},
+		accessModes: map[string][]corev1.PersistentVolumeAccessMode{},
+	}
+
+	for key, value := range map[string]interface{}{TrashConfigMapKey: &index.volumes, TrashAccessModesConfigMapKey: &index.accessModes} {
+		if data := cm.Data[key]; data != "" {
+			if err := json.Unmarshal([]byte(data), value); err != nil {
+				return nil, fmt.Errorf("failed to decode trash configmap %s/%s: %v", cm.Namespace, cm.Name, err)
+			}
+		}
+	}
+
+	return index, nil
+}
+
+// PurgeTrash deletes the volumes from the storage, if they are in the trash longer than the retention period.
+func (d *ControllerService) PurgeTrash(ctx context.Context, retention time.Duration) error {
+	if d.Trash == nil {
+		return nil
+	}
+
+	volumes, err := d.Trash.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for volumeID, deletedAt := range volumes {
+		if time.Since(deletedAt) < retention {
+			continue
+		}
+
+		vol, err := volume.NewVolumeFromVolumeID(volumeID)
+		if err != nil {
+			klog.Errorf("PurgeTrash: wrong volume %s in the trash: %v", volumeID, err)
+
+			continue
+		}
+
+		if err := d.purgeVolume(ctx, vol); err != nil {
+			klog.Errorf("PurgeTrash: failed to delete volume %s: %v", volumeID, err)
+
+			continue
+		}
+
+		if err := d.Trash.Remove(ctx, volumeID); err != nil {
+			return err
+		}
+
+		klog.V(3).Infof("PurgeTrash: volume %s deleted at %s is purged", volumeID, deletedAt)
+	}
+
+	return nil
+}
+
+// purgeVolume deletes the volume from the storage, if it still exists.
+func (d *ControllerService) purgeVolume(ctx context.Context, vol *volume.Volume) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
+	if err != nil {
+		return err
+	}
+
+	cache := d.getAPICache(vol.Cluster())
+
+	exist, err := isPvcExists(ctx, cl, cache, vol)
+	if err != nil || !exist {
+		return err
+	}
+
+	if err := d.untrashVolume(ctx, cl, vol); err != nil {
+		return err
+	}
+
+	return deleteVolume(ctx, cl, cache, vol)
+}
+
+// trashVolume attaches the volume to the new stopped VM csi-trash-<vmid> on the volume node,
+// so the deleted volume is marked on the Proxmox side. The VM description has the volume ID and the deletion time.
+// The volume already attached to a trash VM is not changed.
+// The directories of the network volumes cannot be attached to a VM, they are kept only in the trash index.
+func (d *ControllerService) trashVolume(ctx context.Context, cl *pxapi.Client, vol *volume.Volume, deletedAt time.Time) error {
+	if isNetworkVolume(vol) {
+		return nil
+	}
+
+	attachments, err := d.lookupVolume(ctx, cl, vol, "")
+	if err != nil {
+		return fmt.Errorf("failed to find the volume attachments: %v", err)
+	}
+
+	for _, attachment := range attachments {
+		if !attachment.Trash {
+			return fmt.Errorf("%s: %w to vm %s", vol.Disk(), errVolumeAttached, attachment.Name)
+		}
+	}
+
+	if len(attachments) > 0 {
+		return nil
+	}
+
+	id, err := createTemporaryVM(cl, &d.vmIDLock, vol.Node(), trashVMPrefix)
+	if err != nil {
+		return err
+	}
+
+	vmr := pxapi.NewVmRef(id)
+	vmr.SetNode(vol.Node())
+	vmr.SetVmType("qemu")
+
+	vmParams := map[string]interface{}{
+		// The volumes use the luns starting from 1, see isVolumeAttached
+		deviceNamePrefix + "1": fmt.Sprintf("%s:%s,backup=0", vol.Storage(), vol.Disk()),
+		"description": fmt.Sprintf("Volume %s %s at %s, it is kept in the trash.",
+			vol.VolumeID(), trashVMMarker, deletedAt.UTC().Format(time.RFC3339)),
+	}
+
+	if _, err = cl.SetVmConfig(vmr, vmParams); err != nil {
+		if _, derr := cl.DeleteVmParams(vmr, map[string]interface{}{"purge": 1, "destroy-unreferenced-disks": 0}); derr != nil {
+			klog.Warningf("failed to delete trash vm %d: %v", id, derr)
+		}
+
+		return fmt.Errorf("failed to attach disk to trash vm %d: %v", id, err)
+	}
+
+	return nil
+}
+
+// untrashVolume detaches the volume from the trash VMs and deletes them.
+// The volumes moved to the trash by the older versions are not attached to any VM.
+func (d *ControllerService) untrashVolume(ctx context.Context, cl *pxapi.Client, vol *volume.Volume) error {
+	if isNetworkVolume(vol) {
+		return nil
+	}
+
+	idx := d.getVolumeIndex(vol.Cluster())
+
+	attachments, err := d.lookupVolume(ctx, cl, vol, "")
+	if err != nil {
+		return fmt.Errorf("failed to find the volume attachments: %v", err)
+	}
+
+	for _, attachment := range attachments {
+		if !attachment.Trash {
+			continue
+		}
+
+		err := deleteTemporaryVM(ctx, cl, attachment.VM, vol.Disk())
+		idx.invalidate(attachment.VM.VmId())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isTrashVM returns true if the VM holds the volume in the trash.
+// The VMs of the users can have the same name prefix, so the trash VM is also matched by the description marker.
+func isTrashVM(name string, vmConfig map[string]interface{}) bool {
+	description, _ := vmConfig["description"].(string) //nolint:errcheck
+
+	return strings.HasPrefix(name, trashVMPrefix+"-") && strings.Contains(description, trashVMMarker)
+}
+
+// RestoreVolume detaches the volume from the trash VM and returns it, so it can be used by a new PV.
+// The StorageClass parameters are returned in the volume context together with the PVC metadata.
+// The volume stays in the trash, the caller removes it after the PV is created.
+func (d *ControllerService) RestoreVolume(ctx context.Context, volumeID string, params map[string]string) (*csi.Volume, error) {
+	if d.Trash == nil {
+		return nil, fmt.Errorf("volume trash is not enabled")
+	}
+
+	volumes, err := d.Trash.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := volumes[volumeID]; !ok {
+		return nil, fmt.Errorf("volume %s is not in the trash", volumeID)
+	}
+
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	cl, err := d.Cluster.GetProxmoxCluster(ctx, vol.Cluster())
+	if err != nil {
+		return nil, err
+	}
+
+	cache := d.getAPICache(vol.Cluster())
+
+	size, err := getVolumeSize(ctx, cl, cache, vol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get volume %s: %v", volumeID, err)
+	}
+
+	storageConfig, err := cache.storageConfig(ctx, cl, vol.Storage())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage %s config: %v", vol.Storage(), err)
+	}
+
+	segments := map[string]string{
+		corev1.LabelTopologyRegion: vol.Region(),
+		corev1.LabelTopologyZone:   vol.Zone(),
+	}
+	if storageConfig["shared"] != nil && int(storageConfig["shared"].(float64)) == 1 {
+		delete(segments, corev1.LabelTopologyZone)
+	}
+
+	// The restore command needs the PV and PVC names of the deleted volume, they are not set if the disk name cannot be parsed
+	volCtx, err := getVolumeMetadata(vol.Disk())
+	if err != nil {
+		klog.V(4).Infof("RestoreVolume: no PVC metadata of volume %s: %v", volumeID, err)
+
+		volCtx = map[string]string{}
+	}
+
+	for k, v := range params {
+		volCtx[k] = v
+	}
+
+	if isNetworkVolume(vol) {
+		if volCtx, err = networkVolumeContext(storageConfig, vol, volCtx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.untrashVolume(ctx, cl, vol); err != nil {
+		return nil, err
+	}
+
+	return &csi.Volume{
+		VolumeId:           volumeID,
+		CapacityBytes:      size,
+		VolumeContext:      volCtx,
+		AccessibleTopology: []*csi.Topology{{Segments: segments}},
+	}, nil
+}
@@ -0,0 +1,360 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	proto "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/csi"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapTrash(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	trash := csi.NewConfigMapTrash(clientset, "kube-system", "proxmox-csi-trash")
+
+	volumes, err := trash.List(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, volumes)
+
+	deletedAt := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, trash.Add(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-123", deletedAt))
+	assert.Nil(t, trash.Add(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-123", deletedAt.Add(time.Hour)))
+	assert.Nil(t, trash.Add(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-456", deletedAt))
+
+	volumes, err = trash.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-123": deletedAt,
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-456": deletedAt,
+	}, volumes)
+
+	assert.Nil(t, trash.Remove(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-456"))
+	assert.Nil(t, trash.Remove(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist"))
+
+	volumes, err = trash.List(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-123": deletedAt,
+	}, volumes)
+
+	_, err = clientset.CoreV1().ConfigMaps("kube-system").Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "kube-system"},
+		Data:       map[string]string{csi.TrashConfigMapKey: "{"},
+	}, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	_, err = csi.NewConfigMapTrash(clientset, "kube-system", "broken").List(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to decode trash configmap kube-system/broken: unexpected end of JSON input", err.Error())
+}
+
+func (ts *csiTestSuite) TestDeleteVolumeTrash() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ctx := context.Background()
+	ts.s.Trash = csi.NewConfigMapTrash(fake.NewSimpleClientset(&corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-exist"},
+		Spec: corev1.PersistentVolumeSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       csi.DriverName,
+					VolumeHandle: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+				},
+			},
+		},
+	}), "kube-system", "proxmox-csi-trash")
+
+	// The configs of the trash VMs created by the test
+	trashVMs := map[int]map[string]interface{}{}
+	nextID := 110
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/cluster/resources",
+		func(req *http.Request) (*http.Response, error) {
+			vms := []interface{}{
+				map[string]interface{}{"node": "pve-1", "type": "qemu", "vmid": 100, "name": "cluster-1-node-1"},
+			}
+
+			for id, config := range trashVMs {
+				vms = append(vms, map[string]interface{}{"node": "pve-1", "type": "qemu", "vmid": id, "name": config["name"]})
+			}
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": vms})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/cluster/nextid",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": strconv.Itoa(nextID)})
+		},
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu",
+		func(req *http.Request) (*http.Response, error) {
+			if err := req.ParseForm(); err != nil {
+				return nil, err
+			}
+
+			trashVMs[nextID] = map[string]interface{}{"vmid": nextID, "name": req.PostForm.Get("name")}
+			nextID++
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	for _, id := range []int{110, 111, 112} {
+		id := id
+		url := "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/" + strconv.Itoa(id)
+
+		httpmock.RegisterResponder("POST", url+"/config",
+			func(req *http.Request) (*http.Response, error) {
+				if err := req.ParseForm(); err != nil {
+					return nil, err
+				}
+
+				for key := range req.PostForm {
+					trashVMs[id][key] = req.PostForm.Get(key)
+				}
+
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+
+		httpmock.RegisterResponder("GET", url+"/config",
+			func(req *http.Request) (*http.Response, error) {
+				return httpmock.NewJsonResponse(200, map[string]interface{}{"data": trashVMs[id]})
+			},
+		)
+
+		httpmock.RegisterResponder("PUT", url+"/unlink",
+			func(req *http.Request) (*http.Response, error) {
+				delete(trashVMs[id], "scsi1")
+
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+
+		httpmock.RegisterResponder("DELETE", url,
+			func(req *http.Request) (*http.Response, error) {
+				delete(trashVMs, id)
+
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+	}
+
+	for _, volumeID := range []string{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-non-exist",
+	} {
+		resp, err := ts.s.DeleteVolume(ctx, &proto.DeleteVolumeRequest{VolumeId: volumeID})
+		ts.Require().NoError(err)
+		ts.Require().Equal(&proto.DeleteVolumeResponse{}, resp)
+	}
+
+	// The attached volume is in use, it cannot be moved to the trash
+	_, err := ts.s.DeleteVolume(ctx, &proto.DeleteVolumeRequest{VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-123"})
+	ts.Require().Equal(status.Error(codes.FailedPrecondition, "vm-9999-pvc-123: volume is attached to vm cluster-1-node-1"), err)
+
+	// The deleted volumes are marked by the trash VMs with the deletion time, the second deletion does not create a new one
+	descriptions := map[int]interface{}{}
+
+	for id, volumeID := range map[int]string{110: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist", 111: "cluster-1/pve-1/local-lvm/vm-9999-pvc-error"} {
+		descriptions[id] = trashVMs[id]["description"]
+		ts.Require().Regexp(`^Volume `+volumeID+` is deleted by csi.proxmox.sinextra.dev at \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z, it is kept in the trash.$`, descriptions[id])
+	}
+
+	ts.Require().Equal(map[int]map[string]interface{}{
+		110: {
+			"vmid":        110,
+			"name":        "csi-trash-110",
+			"scsi1":       "local-lvm:vm-9999-pvc-exist,backup=0",
+			"description": descriptions[110],
+		},
+		111: {
+			"vmid":        111,
+			"name":        "csi-trash-111",
+			"scsi1":       "local-lvm:vm-9999-pvc-error,backup=0",
+			"description": descriptions[111],
+		},
+	}, trashVMs)
+
+	// The trash VM is not a published node
+	getResp, err := ts.s.ControllerGetVolume(ctx, &proto.ControllerGetVolumeRequest{VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist"})
+	ts.Require().NoError(err)
+	ts.Require().Empty(getResp.Status.PublishedNodeIds)
+
+	volumes, err := ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().ElementsMatch([]string{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+	}, mapKeys(volumes))
+
+	accessModes, err := ts.s.Trash.AccessModes(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist")
+	ts.Require().NoError(err)
+	ts.Require().Equal([]corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, accessModes)
+
+	accessModes, err = ts.s.Trash.AccessModes(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-error")
+	ts.Require().NoError(err)
+	ts.Require().Nil(accessModes)
+
+	ts.Require().NoError(ts.s.PurgeTrash(ctx, time.Hour))
+
+	volumes, err = ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().Len(volumes, 2)
+	ts.Require().Len(trashVMs, 2)
+
+	_, err = ts.s.RestoreVolume(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-123", nil)
+	ts.Require().Error(err)
+	ts.Require().Equal("volume cluster-1/pve-1/local-lvm/vm-9999-pvc-123 is not in the trash", err.Error())
+
+	vol, err := ts.s.RestoreVolume(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist", map[string]string{csi.StorageCacheKey: "none"})
+	ts.Require().NoError(err)
+	ts.Require().Equal(&proto.Volume{
+		VolumeId:      "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist",
+		CapacityBytes: 5 * 1024 * 1024 * 1024,
+		VolumeContext: map[string]string{
+			csi.StorageCacheKey: "none",
+		},
+		AccessibleTopology: []*proto.Topology{
+			{
+				Segments: map[string]string{
+					corev1.LabelTopologyRegion: "cluster-1",
+					corev1.LabelTopologyZone:   "pve-1",
+				},
+			},
+		},
+	}, vol)
+
+	// The restored volume is detached, it stays in the trash until the PV is created
+	ts.Require().NotContains(trashVMs, 110)
+
+	volumes, err = ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().Len(volumes, 2)
+
+	ts.Require().NoError(ts.s.Trash.Remove(ctx, "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist"))
+
+	// The volume is detached from the trash VM, but the storage fails to delete it
+	ts.Require().NoError(ts.s.PurgeTrash(ctx, 0))
+	ts.Require().Empty(trashVMs)
+
+	volumes, err = ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{"cluster-1/pve-1/local-lvm/vm-9999-pvc-error"}, mapKeys(volumes))
+
+	// The PV of the deleted volume is created again, the volume is detached from the trash VM and removed from the trash
+	_, err = ts.s.DeleteVolume(ctx, &proto.DeleteVolumeRequest{VolumeId: "cluster-1/pve-1/local-lvm/vm-9999-pvc-exist"})
+	ts.Require().NoError(err)
+	ts.Require().Contains(trashVMs, 112)
+
+	createResp, err := ts.s.CreateVolume(ctx, &proto.CreateVolumeRequest{
+		Name:       "pvc-exist",
+		Parameters: map[string]string{csi.StorageIDKey: "local-lvm"},
+		VolumeCapabilities: []*proto.VolumeCapability{
+			{
+				AccessMode: &proto.VolumeCapability_AccessMode{Mode: proto.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+				AccessType: &proto.VolumeCapability_Mount{Mount: &proto.VolumeCapability_MountVolume{}},
+			},
+		},
+		CapacityRange: &proto.CapacityRange{RequiredBytes: 5 * 1024 * 1024 * 1024},
+		AccessibilityRequirements: &proto.TopologyRequirement{
+			Preferred: []*proto.Topology{
+				{
+					Segments: map[string]string{
+						corev1.LabelTopologyRegion: "cluster-1",
+						corev1.LabelTopologyZone:   "pve-1",
+					},
+				},
+			},
+		},
+	})
+	ts.Require().NoError(err)
+	ts.Require().Equal("cluster-1/pve-1/local-lvm/vm-9999-pvc-exist", createResp.Volume.VolumeId)
+	ts.Require().Empty(trashVMs)
+
+	volumes, err = ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().Equal([]string{"cluster-1/pve-1/local-lvm/vm-9999-pvc-error"}, mapKeys(volumes))
+
+	// The directory of the network volume cannot be attached to the trash VM, it is only recorded in the trash
+	_, err = ts.s.DeleteVolume(ctx, &proto.DeleteVolumeRequest{VolumeId: "cluster-1/pve-1/smb/9999/vm-9999-pvc-smb.subvol"})
+	ts.Require().NoError(err)
+	ts.Require().Empty(trashVMs)
+
+	volumes, err = ts.s.Trash.List(ctx)
+	ts.Require().NoError(err)
+	ts.Require().ElementsMatch([]string{
+		"cluster-1/pve-1/local-lvm/vm-9999-pvc-error",
+		"cluster-1/pve-1/smb/9999/vm-9999-pvc-smb.subvol",
+	}, mapKeys(volumes))
+}
+
+func (ts *csiTestSuite) TestListVolumesTrash() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ctx := context.Background()
+	ts.s.Trash = csi.NewConfigMapTrash(fake.NewSimpleClientset(), "kube-system", "proxmox-csi-trash")
+
+	// The shared volume was created on pve-2, it is listed on the first node with the storage
+	ts.Require().NoError(ts.s.Trash.Add(ctx, "cluster-1/pve-2/rbd/vm-9999-pvc-shared", time.Now()))
+	// The local volume with the same name on the other node is not in the trash
+	ts.Require().NoError(ts.s.Trash.Add(ctx, "cluster-1/pve-2/local-lvm/vm-9999-pvc-123", time.Now()))
+
+	resp, err := ts.s.ListVolumes(ctx, &proto.ListVolumesRequest{})
+	ts.Require().NoError(err)
+
+	volumes := []string{}
+	for _, entry := range resp.Entries {
+		volumes = append(volumes, entry.Volume.VolumeId)
+	}
+
+	ts.Require().Contains(volumes, "cluster-1/pve-1/local-lvm/vm-9999-pvc-123")
+	ts.Require().Contains(volumes, "cluster-1/pve-1/rbd/vm-9999-pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10.default.data-postgres-0")
+	ts.Require().NotContains(volumes, "cluster-1/pve-1/rbd/vm-9999-pvc-shared")
+}
+
+func mapKeys(m map[string]time.Time) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
@@ -17,15 +17,25 @@ limitations under the License.
 package csi
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"path"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
 
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
 	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/volume"
+
+	"k8s.io/klog/v2"
 )
 
 const (
@@ -34,8 +44,24 @@ const (
 	// TaskTimeout is the timeout in seconds for all task
 	TaskTimeout = 30
 
-	// ErrorNotFound not found error message
-	ErrorNotFound string = "not found"
+	// cleanupTimeout is the timeout of the cleanup after the cancelled request
+	cleanupTimeout = time.Minute
+
+	// maxDiskNameLength is the maximum length of the disk name, LVM allows 127 characters
+	maxDiskNameLength = 120
+
+	diskNameTemplatePV      = "${pv.name}"
+	diskNameTemplateDefault = "${pv.name}.${pvc.namespace}.${pvc.name}"
+)
+
+var (
+	// diskNamePVPattern is the PV name of the external-provisioner, pvc-<uid>
+	diskNamePVPattern = `pvc-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+	// diskNameDefaultRegexp is the disk name of the default template, the namespace has no dots
+	diskNameDefaultRegexp = regexp.MustCompile(fmt.Sprintf(`^vm-%d-(%s)\.([a-z0-9](?:[-a-z0-9]*[a-z0-9])?)\.([a-z0-9][-a-z0-9.]*)$`, vmID, diskNamePVPattern))
+	// diskNamePVRegexp is the disk name of the template without the PVC metadata
+	diskNamePVRegexp = regexp.MustCompile(fmt.Sprintf(`^vm-%d-(%s)$`, vmID, diskNamePVPattern))
 )
 
 type storageContent struct {
@@ -43,8 +69,11 @@ type storageContent struct {
 	size  int64
 }
 
-func getNodeWithStorage(cl *pxapi.Client, storageName string) (string, error) {
-	data, err := cl.GetNodeList()
+// getNodeWithStorage returns the node with the most available space on the storage.
+// The available space is multiplied by the node weight, nodes with zero weight are skipped.
+// If zones is not empty, only the nodes from the list are considered.
+func getNodeWithStorage(ctx context.Context, cl *pxapi.Client, cache *apiCache, storageName string, zones []string, weights map[string]float64, size int64) (string, error) {
+	data, err := cache.nodeList(ctx, cl)
 	if err != nil {
 		return "", fmt.Errorf("failed to get node list: %v", err)
 	}
@@ -53,35 +82,480 @@ func getNodeWithStorage(cl *pxapi.Client, storageName string) (string, error) {
 		return "", fmt.Errorf("failed to parce node list: %v", err)
 	}
 
+	var (
+		bestNode  string
+		bestScore float64
+	)
+
 	for _, item := range data["data"].([]interface{}) {
 		node, ok := item.(map[string]interface{})
 		if !ok {
 			continue
 		}
 
+		nodeName, _ := node["node"].(string) //nolint:errcheck
+		if nodeName == "" || (node["status"] != nil && node["status"].(string) != "online") {
+			continue
+		}
+
+		if len(zones) > 0 && !slices.Contains(zones, nodeName) {
+			continue
+		}
+
+		weight := 1.0
+		if w, ok := weights[nodeName]; ok {
+			weight = w
+		}
+
+		if weight <= 0 {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
 		vmr := pxapi.NewVmRef(vmID)
-		vmr.SetNode(node["node"].(string))
+		vmr.SetNode(nodeName)
 		vmr.SetVmType("qemu")
 
-		if _, err := cl.GetStorageStatus(vmr, storageName); err == nil {
-			return vmr.Node(), nil
+		storage, err := cl.GetStorageStatus(vmr, storageName)
+		if err != nil {
+			klog.V(4).Infof("getNodeWithStorage: failed to get storage %s status on node %s: %v", storageName, nodeName, err)
+
+			continue
+		}
+
+		if !isStorageActive(storage) {
+			continue
+		}
+
+		avail, _ := storage["avail"].(float64) //nolint:errcheck
+		if int64(avail) < size {
+			continue
+		}
+
+		if score := avail * weight; bestNode == "" || score > bestScore {
+			bestNode, bestScore = nodeName, score
+		}
+	}
+
+	if bestNode == "" {
+		return "", fmt.Errorf("failed to find node with storage %s", storageName)
+	}
+
+	return bestNode, nil
+}
+
+// findVolumeNode returns the storage and the node which already have the volume, or empty strings if none has it.
+// The node of the new volume depends on the free space, so the retried request must find the volume created by the previous one.
+// If zones is not empty, only the nodes from the list are considered. The offline nodes are skipped,
+// and the shared storage is checked only on the first node where its content is readable, it is the same on all nodes.
+func findVolumeNode(ctx context.Context, cl *pxapi.Client, cache *apiCache, region string, storages, zones []string, name, format string) (string, string, error) {
+	data, err := cache.nodeList(ctx, cl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get node list: %v", err)
+	}
+
+	items, _ := data["data"].([]interface{}) //nolint:errcheck
+
+	for _, storageName := range storages {
+		storageConfig, err := cache.storageConfig(ctx, cl, storageName)
+		if err != nil {
+			klog.V(4).Infof("findVolumeNode: failed to get storage %s config: %v", storageName, err)
+
+			continue
+		}
+
+		disk := getVolumeDiskName(storageConfig, name, format)
+		shared := storageConfig["shared"] != nil && int(storageConfig["shared"].(float64)) == 1
+
+		for _, item := range items {
+			node, ok := item.(map[string]interface{})
+			if !ok || node["status"] != "online" {
+				continue
+			}
+
+			nodeName, _ := node["node"].(string) //nolint:errcheck
+			if nodeName == "" || (len(zones) > 0 && !slices.Contains(zones, nodeName)) {
+				continue
+			}
+
+			if err := ctx.Err(); err != nil {
+				return "", "", err
+			}
+
+			exist, err := isPvcExists(ctx, cl, cache, volume.NewVolume(region, nodeName, storageName, disk))
+			if err != nil {
+				klog.V(4).Infof("findVolumeNode: failed to get storage %s content on node %s: %v", storageName, nodeName, err)
+
+				continue
+			}
+
+			if exist {
+				return storageName, nodeName, nil
+			}
+
+			if shared {
+				break
+			}
+		}
+	}
+
+	return "", "", nil
+}
+
+func isStorageActive(storage map[string]interface{}) bool {
+	for _, key := range []string{"active", "enabled"} {
+		if v, ok := storage[key].(float64); ok && int(v) != 1 {
+			return false
 		}
 	}
 
-	return "", fmt.Errorf("failed to find node with storage %s", storageName)
+	return true
+}
+
+func isThinStorage(storageType string) bool {
+	switch storageType {
+	case "lvmthin", "zfspool", "rbd":
+		return true
+	}
+
+	return false
 }
 
-func getStorageContent(cl *pxapi.Client, vol *volume.Volume) (*storageContent, error) {
+// parseOvercommitRatio parses the overcommit ratio of thin-provisioned storages, the default is 1.
+func parseOvercommitRatio(value string) (float64, error) {
+	if value == "" {
+		return 1, nil
+	}
+
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio < 1 {
+		return 0, fmt.Errorf("overcommit ratio %q must be a number greater than or equal to 1", value)
+	}
+
+	return ratio, nil
+}
+
+// parseZoneWeights parses the zone weights in the format "zone1=2,zone2=0.5".
+func parseZoneWeights(value string) (map[string]float64, error) {
+	weights := map[string]float64{}
+
+	if value == "" {
+		return weights, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		zone, weight, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || zone == "" {
+			return nil, fmt.Errorf("zone weight %q must be in the format zone=weight", item)
+		}
+
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("zone weight %q must be a positive number", item)
+		}
+
+		weights[zone] = w
+	}
+
+	return weights, nil
+}
+
+// parseStorageSelector parses the storage selector in the format "type=lvmthin,content=images".
+func parseStorageSelector(value string) (map[string]string, error) {
+	selector := map[string]string{}
+
+	for _, item := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("selector %q must be in the format key=value", item)
+		}
+
+		switch key {
+		case "type", "content":
+			selector[key] = val
+		default:
+			return nil, fmt.Errorf("selector key %q is not supported, must be type or content", key)
+		}
+	}
+
+	return selector, nil
+}
+
+// parseStorageList returns the ordered list of storages from the comma separated storage parameter.
+func parseStorageList(value string) []string {
+	storages := []string{}
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			storages = append(storages, name)
+		}
+	}
+
+	return storages
+}
+
+// getStorageCandidates returns the ordered list of storages from the storage parameter,
+// or the enabled storages matched by the storage selector.
+func getStorageCandidates(ctx context.Context, cl *pxapi.Client, cache *apiCache, params map[string]string) ([]string, error) {
+	if params[StorageSelectorKey] == "" {
+		return parseStorageList(params[StorageIDKey]), nil
+	}
+
+	selector, err := parseStorageSelector(params[StorageSelectorKey])
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cache.storageList(ctx, cl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage list: %v", err)
+	}
+
+	items, ok := data["data"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to parse storage list")
+	}
+
+	storages := []string{}
+
+	for _, item := range items {
+		storage, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if disable, ok := storage["disable"].(float64); ok && int(disable) == 1 {
+			continue
+		}
+
+		if t, ok := selector["type"]; ok && storage["type"] != t {
+			continue
+		}
+
+		if c, ok := selector["content"]; ok {
+			content, _ := storage["content"].(string) //nolint:errcheck
+			if !slices.Contains(strings.Split(content, ","), c) {
+				continue
+			}
+		}
+
+		if name, _ := storage["storage"].(string); name != "" { //nolint:errcheck
+			storages = append(storages, name)
+		}
+	}
+
+	slices.Sort(storages)
+
+	if len(storages) == 0 {
+		return nil, fmt.Errorf("no storage matches the selector %q", params[StorageSelectorKey])
+	}
+
+	return storages, nil
+}
+
+// getStorageOnNode returns the first storage from the list which already has the volume,
+// otherwise the first active storage with enough free space on the node.
+func getStorageOnNode(ctx context.Context, cl *pxapi.Client, cache *apiCache, region, node string, storages []string, name, format string, size int64) (string, error) {
+	for _, storageName := range storages {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		storageConfig, err := cache.storageConfig(ctx, cl, storageName)
+		if err != nil {
+			klog.V(4).Infof("getStorageOnNode: failed to get storage %s config: %v", storageName, err)
+
+			continue
+		}
+
+		vol := volume.NewVolume(region, node, storageName, getVolumeDiskName(storageConfig, name, format))
+
+		exist, err := isPvcExists(ctx, cl, cache, vol)
+		if err != nil {
+			klog.V(4).Infof("getStorageOnNode: failed to check volume %s: %v", vol.VolumeID(), err)
+
+			continue
+		}
+
+		if exist {
+			return storageName, nil
+		}
+	}
+
 	vmr := pxapi.NewVmRef(vmID)
-	vmr.SetNode(vol.Node())
+	vmr.SetNode(node)
 	vmr.SetVmType("qemu")
 
-	context, err := cl.GetStorageContent(vmr, vol.Storage())
+	for _, storageName := range storages {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		storage, err := cl.GetStorageStatus(vmr, storageName)
+		if err != nil {
+			klog.V(4).Infof("getStorageOnNode: failed to get storage %s status on node %s: %v", storageName, node, err)
+
+			continue
+		}
+
+		if avail, _ := storage["avail"].(float64); isStorageActive(storage) && int64(avail) >= size { //nolint:errcheck
+			return storageName, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find storage with enough free space on node %s", node)
+}
+
+// getVolumeName returns the disk name of the volume without the storage specific prefix and suffix.
+// The name is rendered from the disk name template, Proxmox requires the prefix vm-<vmid>-.
+// The default template has the PVC metadata if the provisioner passes it: vm-9999-<pv>.<namespace>.<pvc>
+func getVolumeName(pv string, params map[string]string) string {
+	template := params[StorageDiskNameTemplateKey]
+	if template == "" {
+		template = diskNameTemplatePV
+		if params[PVCNamespaceKey] != "" && params[PVCNameKey] != "" {
+			template = diskNameTemplateDefault
+		}
+	}
+
+	name := strings.NewReplacer(
+		"${pv.name}", pv,
+		"${pvc.namespace}", params[PVCNamespaceKey],
+		"${pvc.name}", params[PVCNameKey],
+	).Replace(template)
+
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+
+		return '-'
+	}, name)
+
+	name = fmt.Sprintf("vm-%d-%s", vmID, name)
+	if len(name) > maxDiskNameLength {
+		// The overflow is replaced by the hash of the full name, so the truncated names of different PVs do not match
+		hash := sha256.Sum256([]byte(name))
+		suffix := "-" + hex.EncodeToString(hash[:4])
+
+		name = name[:maxDiskNameLength-len(suffix)] + suffix
+	}
+
+	return name
+}
+
+// checkDiskNameTemplate checks the placeholders of the disk name template.
+// The PV name is required to keep the name unique, so a new PVC never gets the retained disk of the old one.
+func checkDiskNameTemplate(template string) error {
+	if !strings.Contains(template, "${pv.name}") {
+		return fmt.Errorf("template %q must have the ${pv.name} placeholder", template)
+	}
+
+	rest := strings.NewReplacer("${pv.name}", "", "${pvc.namespace}", "", "${pvc.name}", "").Replace(template)
+	if strings.Contains(rest, "${") {
+		return fmt.Errorf("template %q has unknown placeholder, supported: ${pv.name}, ${pvc.namespace}, ${pvc.name}", template)
+	}
+
+	if template == diskNameTemplateDefault || template == diskNameTemplatePV {
+		return nil
+	}
+
+	// The metadata is parsed from the names of the built-in templates, the other template must not render them
+	for _, pvcName := range []string{"name", "name.sub"} {
+		name := getVolumeName("pvc-00000000-0000-0000-0000-000000000000", map[string]string{
+			StorageDiskNameTemplateKey: template,
+			PVCNamespaceKey:            "namespace",
+			PVCNameKey:                 pvcName,
+		})
+
+		if _, err := getVolumeMetadata(name); err == nil {
+			return fmt.Errorf("template %q renders the names of the built-in templates, use %s or %s", template, diskNameTemplateDefault, diskNameTemplatePV)
+		}
+	}
+
+	return nil
+}
+
+// getVolumeMetadata returns the PV and PVC metadata from the disk name.
+// The disk name has no record of its template, so only the names of the built-in templates are parsed,
+// and checkDiskNameTemplate rejects the templates which render the same names.
+// The PV name must be the one of the external-provisioner, pvc-<uid>. The truncated names are not parsed.
+func getVolumeMetadata(disk string) (map[string]string, error) {
+	name := path.Base(disk)
+	if strings.Contains(disk, "/") {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+
+	if len(name) >= maxDiskNameLength {
+		return nil, fmt.Errorf("disk name %s may be truncated", name)
+	}
+
+	if m := diskNameDefaultRegexp.FindStringSubmatch(name); m != nil {
+		return map[string]string{
+			PVNameKey:       m[1],
+			PVCNamespaceKey: m[2],
+			PVCNameKey:      m[3],
+		}, nil
+	}
+
+	if m := diskNamePVRegexp.FindStringSubmatch(name); m != nil {
+		return map[string]string{
+			PVNameKey: m[1],
+		}, nil
+	}
+
+	return nil, fmt.Errorf("disk name %s does not match the disk name templates %s or %s", name, diskNameTemplateDefault, diskNameTemplatePV)
+}
+
+// getVolumeDiskName returns the disk name of the volume on the storage.
+// The disk format is used only by directory-based storages, the default is raw.
+func getVolumeDiskName(storageConfig map[string]interface{}, name string, format string) string {
+	storageType, _ := storageConfig["type"].(string) //nolint:errcheck
+	if isNetworkStorage(storageType) {
+		return fmt.Sprintf("%d/%s.subvol", vmID, name)
+	}
+
+	if isDirectoryStorage(storageConfig) {
+		if format == "" {
+			format = "raw"
+		}
+
+		return fmt.Sprintf("%d/%s.%s", vmID, name, format)
+	}
+
+	return name
+}
+
+func isDirectoryStorage(storageConfig map[string]interface{}) bool {
+	storagePath, _ := storageConfig["path"].(string) //nolint:errcheck
+
+	return storagePath != ""
+}
+
+// isDiskFormatSupported checks the disk format, block storages and network volumes support only raw disks.
+func isDiskFormatSupported(storageConfig map[string]interface{}, format string) bool {
+	switch format {
+	case "", "raw":
+		return true
+	case "qcow2", "vmdk":
+		storageType, _ := storageConfig["type"].(string) //nolint:errcheck
+
+		return isDirectoryStorage(storageConfig) && !isNetworkStorage(storageType)
+	}
+
+	return false
+}
+
+func getStorageContent(ctx context.Context, cl *pxapi.Client, cache *apiCache, vol *volume.Volume) (*storageContent, error) {
+	content, err := cache.storageContent(ctx, cl, vol.Node(), vol.Storage())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get storage list: %v", err)
 	}
 
-	images, ok := context["data"].([]interface{})
+	images, ok := content["data"].([]interface{})
 	if !ok {
 		return nil, fmt.Errorf("failed to cast images to map: %v", err)
 	}
@@ -105,8 +579,8 @@ func getStorageContent(cl *pxapi.Client, vol *volume.Volume) (*storageContent, e
 	return nil, nil
 }
 
-func isPvcExists(cl *pxapi.Client, vol *volume.Volume) (bool, error) {
-	st, err := getStorageContent(cl, vol)
+func isPvcExists(ctx context.Context, cl *pxapi.Client, cache *apiCache, vol *volume.Volume) (bool, error) {
+	st, err := getStorageContent(ctx, cl, cache, vol)
 	if err != nil {
 		return false, err
 	}
@@ -114,19 +588,37 @@ func isPvcExists(cl *pxapi.Client, vol *volume.Volume) (bool, error) {
 	return st != nil, nil
 }
 
-func getVolumeSize(cl *pxapi.Client, vol *volume.Volume) (int64, error) {
-	st, err := getStorageContent(cl, vol)
+func getVolumeSize(ctx context.Context, cl *pxapi.Client, cache *apiCache, vol *volume.Volume) (int64, error) {
+	st, err := getStorageContent(ctx, cl, cache, vol)
 	if err != nil {
 		return 0, err
 	}
 
 	if st == nil {
-		return 0, fmt.Errorf(ErrorNotFound)
+		return 0, proxmox.ErrNotFound
 	}
 
 	return st.size, nil
 }
 
+func deleteVolume(ctx context.Context, cl *pxapi.Client, cache *apiCache, vol *volume.Volume) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer cache.invalidateContent(vol.Storage())
+
+	vmr := pxapi.NewVmRef(vmID)
+	vmr.SetNode(vol.Node())
+	vmr.SetVmType("qemu")
+
+	if _, err := cl.DeleteVolume(vmr, vol.Storage(), vol.Disk()); err != nil {
+		return fmt.Errorf("failed to delete volume: %s", vol.Disk())
+	}
+
+	return nil
+}
+
 func isVolumeAttached(vmConfig map[string]interface{}, pvc string) (int, bool) {
 	if pvc == "" {
 		return 0, false
@@ -143,9 +635,14 @@ func isVolumeAttached(vmConfig map[string]interface{}, pvc string) (int, bool) {
 	return 0, false
 }
 
-func waitForVolumeAttach(cl *pxapi.Client, vmr *pxapi.VmRef, lun int) error {
-	waited := 0
-	for waited < TaskTimeout {
+func waitForVolumeAttach(ctx context.Context, cl *pxapi.Client, vmr *pxapi.VmRef, lun int) error {
+	ctx, cancel := context.WithTimeout(ctx, TaskTimeout*time.Second)
+	defer cancel()
+
+	ticker := time.NewTicker(TaskStatusCheckInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
 		config, err := cl.GetVmConfig(vmr)
 		if err != nil {
 			return fmt.Errorf("failed to get vm config: %v", err)
@@ -156,18 +653,29 @@ func waitForVolumeAttach(cl *pxapi.Client, vmr *pxapi.VmRef, lun int) error {
 			return nil
 		}
 
-		time.Sleep(TaskStatusCheckInterval * time.Second)
-		waited += TaskStatusCheckInterval
-	}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timeout waiting for disk to attach")
+			}
 
-	return fmt.Errorf("timeout waiting for disk to attach")
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
-func waitForVolumeDetach(_ *pxapi.Client, _ *pxapi.VmRef, _ int) error {
+func waitForVolumeDetach(_ context.Context, _ *pxapi.Client, _ *pxapi.VmRef, _ int) error {
 	return nil
 }
 
-func createVolume(cl *pxapi.Client, vol *volume.Volume, sizeGB int) error {
+func createVolume(ctx context.Context, cl *pxapi.Client, cache *apiCache, vol *volume.Volume, sizeGB int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer cache.invalidateContent(vol.Storage())
+
 	filename := strings.Split(vol.Disk(), "/")
 	diskParams := map[string]interface{}{
 		"vmid":     vmID,
@@ -175,6 +683,17 @@ func createVolume(cl *pxapi.Client, vol *volume.Volume, sizeGB int) error {
 		"size":     fmt.Sprintf("%dG", sizeGB),
 	}
 
+	// Disks on directory-based storages have the format extension
+	if ext := path.Ext(vol.Disk()); ext != "" && strings.Contains(vol.Disk(), "/") {
+		diskParams["format"] = strings.TrimPrefix(ext, ".")
+	}
+
+	// Proxmox creates a directory instead of an image, if the size is zero
+	if isNetworkVolume(vol) {
+		diskParams["format"] = "subvol"
+		diskParams["size"] = "0"
+	}
+
 	err := cl.CreateVMDisk(vol.Node(), vol.Storage(), fmt.Sprintf("%s:%s", vol.Storage(), vol.Disk()), diskParams)
 	if err != nil {
 		return fmt.Errorf("failed to create vm disk: %v", err)
@@ -183,7 +702,7 @@ func createVolume(cl *pxapi.Client, vol *volume.Volume, sizeGB int) error {
 	return nil
 }
 
-func attachVolume(cl *pxapi.Client, vmr *pxapi.VmRef, storageName string, pvc string, options map[string]string) (map[string]string, error) {
+func attachVolume(ctx context.Context, cl *pxapi.Client, vmr *pxapi.VmRef, storageName string, pvc string, options map[string]string) (map[string]string, error) {
 	config, err := cl.GetVmConfig(vmr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get vm config: %v", err)
@@ -210,12 +729,16 @@ func attachVolume(cl *pxapi.Client, vmr *pxapi.VmRef, storageName string, pvc st
 					deviceNamePrefix + strconv.Itoa(lun): fmt.Sprintf("%s:%s,%s", storageName, pvc, strings.Join(opt, ",")),
 				}
 
+				if err = ctx.Err(); err != nil {
+					return nil, err
+				}
+
 				_, err = cl.SetVmConfig(vmr, vmParams)
 				if err != nil {
 					return nil, fmt.Errorf("failed to attach disk: %v, vmParams=%+v", err, vmParams)
 				}
 
-				if err := waitForVolumeAttach(cl, vmr, lun); err != nil {
+				if err := waitForVolumeAttach(ctx, cl, vmr, lun); err != nil {
 					return nil, fmt.Errorf("failed to wait for disk attach: %v", err)
 				}
 
@@ -234,7 +757,7 @@ func attachVolume(cl *pxapi.Client, vmr *pxapi.VmRef, storageName string, pvc st
 	return nil, fmt.Errorf("no free lun found")
 }
 
-func detachVolume(cl *pxapi.Client, vmr *pxapi.VmRef, pvc string) error {
+func detachVolume(ctx context.Context, cl *pxapi.Client, vmr *pxapi.VmRef, pvc string) error {
 	config, err := cl.GetVmConfig(vmr)
 	if err != nil {
 		return fmt.Errorf("failed to get vm config: %v", err)
@@ -249,14 +772,174 @@ func detachVolume(cl *pxapi.Client, vmr *pxapi.VmRef, pvc string) error {
 		"idlist": fmt.Sprintf("%s%d", deviceNamePrefix, lun),
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
 	err = cl.Put(vmParams, "/nodes/"+vmr.Node()+"/qemu/"+strconv.Itoa(vmr.VmId())+"/unlink")
 	if err != nil {
 		return fmt.Errorf("failed to set vm config: %v, vmParams=%+v", err, vmParams)
 	}
 
-	if err := waitForVolumeDetach(cl, vmr, lun); err != nil {
+	if err := waitForVolumeDetach(ctx, cl, vmr, lun); err != nil {
 		return fmt.Errorf("failed to wait for disk detach: %v", err)
 	}
 
 	return nil
 }
+
+// resizeDetachedVolume resizes the volume which is not attached to any VM.
+// Proxmox resizes only the disks of VMs, and a VM ID belongs to one node, so the placeholder VM cannot be used for local storages.
+// It creates the stopped VM csi-resize-<vmid> on the volume node, attaches the volume as scsi1, resizes it,
+// unlinks the volume from the VM config without deleting it, and purges the VM.
+// The VM is purged even if the request is cancelled, but it is kept if the volume cannot be unlinked.
+// The vmIDLock is held from the next VM ID request until the VM is created, so the concurrent resizes get different IDs.
+func resizeDetachedVolume(ctx context.Context, cluster *proxmox.Cluster, vmIDLock sync.Locker, vol *volume.Volume, size string) error {
+	cl, err := cluster.GetProxmoxCluster(ctx, vol.Cluster())
+	if err != nil {
+		return err
+	}
+
+	id, err := createTemporaryVM(cl, vmIDLock, vol.Node(), "csi-resize")
+	if err != nil {
+		return err
+	}
+
+	vmr := pxapi.NewVmRef(id)
+	vmr.SetNode(vol.Node())
+	vmr.SetVmType("qemu")
+
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+
+	ccl, cerr := cluster.GetProxmoxCluster(cleanupCtx, vol.Cluster())
+	if cerr != nil {
+		return cerr
+	}
+
+	// The volumes use the luns starting from 1, see isVolumeAttached
+	device := deviceNamePrefix + "1"
+
+	_, err = cl.SetVmConfig(vmr, map[string]interface{}{device: fmt.Sprintf("%s:%s,backup=0", vol.Storage(), vol.Disk())})
+	if err == nil {
+		if _, err = cl.ResizeQemuDiskRaw(vmr, device, size); err != nil {
+			err = fmt.Errorf("failed to resize disk: %v", err)
+		}
+	} else {
+		err = fmt.Errorf("failed to attach disk to temporary vm %d: %v", id, err)
+	}
+
+	if derr := deleteTemporaryVM(cleanupCtx, ccl, vmr, vol.Disk()); derr != nil {
+		return derr
+	}
+
+	return err
+}
+
+// createTemporaryVM creates the stopped VM <prefix>-<vmid> without disks on the node, and returns its ID.
+func createTemporaryVM(cl *pxapi.Client, vmIDLock sync.Locker, node, prefix string) (int, error) {
+	vmIDLock.Lock()
+	defer vmIDLock.Unlock()
+
+	id, err := cl.GetNextID(0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get next vm id: %v", err)
+	}
+
+	vmParams := map[string]interface{}{
+		"vmid": id,
+		"name": fmt.Sprintf("%s-%d", prefix, id),
+	}
+
+	if _, err = cl.CreateQemuVm(node, vmParams); err != nil {
+		return 0, fmt.Errorf("failed to create temporary vm %d: %v", id, err)
+	}
+
+	return id, nil
+}
+
+// deleteTemporaryVM detaches the volume from the temporary VM and deletes the VM.
+// The VM does not own the volume (vm-9999-*), so Proxmox keeps it when the VM is deleted,
+// but the VM is kept if the volume is not detached, so the volume is never left in the config of a half-deleted VM.
+func deleteTemporaryVM(ctx context.Context, cl *pxapi.Client, vmr *pxapi.VmRef, disk string) error {
+	if err := detachVolume(ctx, cl, vmr, disk); err != nil {
+		return fmt.Errorf("failed to detach disk from temporary vm %d, delete the vm manually after detaching the disk: %v", vmr.VmId(), err)
+	}
+
+	if _, err := cl.DeleteVmParams(vmr, map[string]interface{}{"purge": 1, "destroy-unreferenced-disks": 0}); err != nil {
+		klog.Warningf("failed to delete temporary vm %d: %v", vmr.VmId(), err)
+	}
+
+	return nil
+}
+
+func isNetworkStorage(storageType string) bool {
+	switch storageType {
+	case "nfs", "cifs":
+		return true
+	}
+
+	return false
+}
+
+func isNetworkVolume(vol *volume.Volume) bool {
+	return strings.HasSuffix(vol.Disk(), ".subvol")
+}
+
+// networkVolumeContext returns the volume context with the mount source of the volume directory.
+func networkVolumeContext(storageConfig map[string]interface{}, vol *volume.Volume, params map[string]string) (map[string]string, error) {
+	server, _ := storageConfig["server"].(string) //nolint:errcheck
+	if server == "" {
+		return nil, fmt.Errorf("storage %s has no server", vol.Storage())
+	}
+
+	volCtx := make(map[string]string, len(params)+3)
+	for k, v := range params {
+		volCtx[k] = v
+	}
+
+	options := []string{}
+
+	switch storageConfig["type"].(string) {
+	case "nfs":
+		export, _ := storageConfig["export"].(string) //nolint:errcheck
+		if export == "" {
+			return nil, fmt.Errorf("storage %s has no export", vol.Storage())
+		}
+
+		if opts, ok := storageConfig["options"].(string); ok && opts != "" {
+			options = append(options, opts)
+		}
+
+		volCtx[MountFSTypeKey] = FSTypeNFS
+		volCtx[MountSourceKey] = fmt.Sprintf("%s:%s", server, path.Join(export, "images", vol.Disk()))
+	case "cifs":
+		share, _ := storageConfig["share"].(string) //nolint:errcheck
+		if share == "" {
+			return nil, fmt.Errorf("storage %s has no share", vol.Storage())
+		}
+
+		subdir, _ := storageConfig["subdir"].(string) //nolint:errcheck
+
+		if version, ok := storageConfig["smbversion"].(string); ok && version != "" && version != "default" {
+			options = append(options, "vers="+version)
+		}
+
+		if domain, ok := storageConfig["domain"].(string); ok && domain != "" {
+			options = append(options, "domain="+domain)
+		}
+
+		if username, ok := storageConfig["username"].(string); ok && username != "" {
+			options = append(options, "username="+username)
+		}
+
+		volCtx[MountFSTypeKey] = FSTypeCIFS
+		volCtx[MountSourceKey] = "//" + path.Join(server, share, subdir, "images", vol.Disk())
+	default:
+		return nil, fmt.Errorf("storage %s is not a network storage", vol.Storage())
+	}
+
+	volCtx[MountOptionsKey] = strings.Join(options, ",")
+
+	return volCtx, nil
+}
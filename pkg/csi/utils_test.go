@@ -17,10 +17,18 @@ limitations under the License.
 package csi
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
+
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/volume"
 )
 
 func TestIsVolumeAttached(t *testing.T) {
@@ -84,3 +92,348 @@ func TestIsVolumeAttached(t *testing.T) {
 		})
 	}
 }
+
+func TestNetworkVolumeContext(t *testing.T) {
+	t.Parallel()
+
+	vol := volume.NewVolume("region", "zone", "share", "9999/vm-9999-pvc-123.subvol")
+
+	tests := []struct {
+		msg           string
+		storageConfig map[string]interface{}
+		expected      map[string]string
+		expectedError error
+	}{
+		{
+			msg: "NoServer",
+			storageConfig: map[string]interface{}{
+				"type": "nfs",
+			},
+			expectedError: fmt.Errorf("storage share has no server"),
+		},
+		{
+			msg: "LocalStorage",
+			storageConfig: map[string]interface{}{
+				"type":   "dir",
+				"server": "10.0.0.1",
+			},
+			expectedError: fmt.Errorf("storage share is not a network storage"),
+		},
+		{
+			msg: "NFS",
+			storageConfig: map[string]interface{}{
+				"type":    "nfs",
+				"server":  "10.0.0.1",
+				"export":  "/data",
+				"options": "vers=4.2",
+			},
+			expected: map[string]string{
+				StorageIDKey:    "share",
+				MountSourceKey:  "10.0.0.1:/data/images/9999/vm-9999-pvc-123.subvol",
+				MountFSTypeKey:  FSTypeNFS,
+				MountOptionsKey: "vers=4.2",
+			},
+		},
+		{
+			msg: "CIFS",
+			storageConfig: map[string]interface{}{
+				"type":       "cifs",
+				"server":     "10.0.0.1",
+				"share":      "data",
+				"subdir":     "/k8s",
+				"smbversion": "3.0",
+				"username":   "user",
+			},
+			expected: map[string]string{
+				StorageIDKey:    "share",
+				MountSourceKey:  "//10.0.0.1/data/k8s/images/9999/vm-9999-pvc-123.subvol",
+				MountFSTypeKey:  FSTypeCIFS,
+				MountOptionsKey: "vers=3.0,username=user",
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			volCtx, err := networkVolumeContext(testCase.storageConfig, vol, map[string]string{StorageIDKey: "share"})
+
+			if testCase.expectedError != nil {
+				assert.NotNil(t, err)
+				assert.Equal(t, testCase.expectedError.Error(), err.Error())
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, testCase.expected, volCtx)
+			}
+		})
+	}
+}
+
+func TestGetVolumeDiskName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg           string
+		storageConfig map[string]interface{}
+		format        string
+		expected      string
+		supported     bool
+	}{
+		{
+			msg:           "Block",
+			storageConfig: map[string]interface{}{"type": "lvmthin"},
+			expected:      "vm-9999-pvc-123",
+			supported:     true,
+		},
+		{
+			msg:           "BlockQcow2",
+			storageConfig: map[string]interface{}{"type": "lvmthin"},
+			format:        "qcow2",
+			expected:      "vm-9999-pvc-123",
+			supported:     false,
+		},
+		{
+			msg:           "Directory",
+			storageConfig: map[string]interface{}{"type": "dir", "path": "/var/lib/vz"},
+			expected:      "9999/vm-9999-pvc-123.raw",
+			supported:     true,
+		},
+		{
+			msg:           "DirectoryQcow2",
+			storageConfig: map[string]interface{}{"type": "dir", "path": "/var/lib/vz"},
+			format:        "qcow2",
+			expected:      "9999/vm-9999-pvc-123.qcow2",
+			supported:     true,
+		},
+		{
+			msg:           "NetworkQcow2",
+			storageConfig: map[string]interface{}{"type": "nfs", "path": "/mnt/pve/nfs"},
+			format:        "qcow2",
+			expected:      "9999/vm-9999-pvc-123.subvol",
+			supported:     false,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, getVolumeDiskName(testCase.storageConfig, "vm-9999-pvc-123", testCase.format))
+			assert.Equal(t, testCase.supported, isDiskFormatSupported(testCase.storageConfig, testCase.format))
+		})
+	}
+}
+
+func TestGetVolumeName(t *testing.T) {
+	t.Parallel()
+
+	pv := "pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10"
+
+	tests := []struct {
+		msg              string
+		params           map[string]string
+		disk             string
+		expected         string
+		expectedMetadata map[string]string
+	}{
+		{
+			msg:      "NoMetadata",
+			params:   map[string]string{},
+			disk:     "vm-9999-" + pv,
+			expected: "vm-9999-" + pv,
+			expectedMetadata: map[string]string{
+				PVNameKey: pv,
+			},
+		},
+		{
+			msg: "Metadata",
+			params: map[string]string{
+				PVCNamespaceKey: "kube-system",
+				PVCNameKey:      "data.db-0",
+			},
+			disk:     "9999/vm-9999-" + pv + ".kube-system.data.db-0.qcow2",
+			expected: "vm-9999-" + pv + ".kube-system.data.db-0",
+			expectedMetadata: map[string]string{
+				PVNameKey:       pv,
+				PVCNamespaceKey: "kube-system",
+				PVCNameKey:      "data.db-0",
+			},
+		},
+		{
+			msg: "Template",
+			params: map[string]string{
+				StorageDiskNameTemplateKey: "${pvc.namespace}-${pvc.name}-${pv.name}",
+				PVCNamespaceKey:            "default",
+				PVCNameKey:                 "data/db:0",
+			},
+			disk:     "vm-9999-default-data-db-0-" + pv,
+			expected: "vm-9999-default-data-db-0-" + pv,
+		},
+		{
+			msg: "TemplateWithoutMetadata",
+			params: map[string]string{
+				StorageDiskNameTemplateKey: "k8s-${pvc.name}-${pv.name}",
+			},
+			expected: "vm-9999-k8s--" + pv,
+		},
+		{
+			msg: "MetadataTruncated",
+			params: map[string]string{
+				PVCNamespaceKey: "default",
+				PVCNameKey:      strings.Repeat("a", 200),
+			},
+			disk:     "vm-9999-" + pv + ".default." + strings.Repeat("a", 54) + "-39364fa7",
+			expected: "vm-9999-" + pv + ".default." + strings.Repeat("a", 54) + "-39364fa7",
+		},
+		{
+			msg:    "UnknownPVName",
+			params: map[string]string{},
+			disk:   "vm-9999-pvc-123",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			if testCase.expected != "" {
+				assert.Equal(t, testCase.expected, getVolumeName(pv, testCase.params))
+			}
+
+			if testCase.disk != "" {
+				metadata, err := getVolumeMetadata(testCase.disk)
+				if testCase.expectedMetadata != nil {
+					assert.Nil(t, err)
+				} else {
+					assert.NotNil(t, err)
+				}
+
+				assert.Equal(t, testCase.expectedMetadata, metadata)
+			}
+		})
+	}
+}
+
+func TestGetVolumeNameTruncatedUnique(t *testing.T) {
+	t.Parallel()
+
+	params := map[string]string{
+		StorageDiskNameTemplateKey: "${pvc.namespace}-${pvc.name}-${pv.name}",
+		PVCNamespaceKey:            strings.Repeat("n", 63),
+		PVCNameKey:                 strings.Repeat("p", 63),
+	}
+
+	name1 := getVolumeName("pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a10", params)
+	name2 := getVolumeName("pvc-0f5e3a52-3b8e-4b8e-9f51-6d1d2f7c9a11", params)
+
+	assert.Len(t, name1, maxDiskNameLength)
+	assert.Len(t, name2, maxDiskNameLength)
+	assert.NotEqual(t, name1, name2)
+}
+
+func TestCheckDiskNameTemplate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		template      string
+		expectedError string
+	}{
+		{
+			template: "${pvc.namespace}-${pv.name}",
+		},
+		{
+			template:      "${pvc.namespace}-${pvc.name}",
+			expectedError: "template \"${pvc.namespace}-${pvc.name}\" must have the ${pv.name} placeholder",
+		},
+		{
+			template:      "${pv.name}.${pvc.name}.${pvc.namespace}",
+			expectedError: "template \"${pv.name}.${pvc.name}.${pvc.namespace}\" renders the names of the built-in templates, use ${pv.name}.${pvc.namespace}.${pvc.name} or ${pv.name}",
+		},
+		{
+			template:      "${pv.name}.${pvc.name}",
+			expectedError: "template \"${pv.name}.${pvc.name}\" renders the names of the built-in templates, use ${pv.name}.${pvc.namespace}.${pvc.name} or ${pv.name}",
+		},
+		{
+			template: diskNameTemplateDefault,
+		},
+		{
+			template:      "${pv.name}-${pvc.uid}",
+			expectedError: "template \"${pv.name}-${pvc.uid}\" has unknown placeholder, supported: ${pv.name}, ${pvc.namespace}, ${pvc.name}",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.template), func(t *testing.T) {
+			t.Parallel()
+
+			err := checkDiskNameTemplate(testCase.template)
+
+			if testCase.expectedError != "" {
+				assert.NotNil(t, err)
+				assert.Equal(t, testCase.expectedError, err.Error())
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestFindVolumeNode(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": []interface{}{
+			map[string]interface{}{"node": "pve-1", "status": "offline"},
+			map[string]interface{}{"node": "pve-2", "status": "online"},
+			map[string]interface{}{"node": "pve-3", "status": "online"},
+		}}),
+	)
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/rbd",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": map[string]interface{}{"type": "rbd", "shared": 1}}),
+	)
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/storage/local-lvm",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": map[string]interface{}{"type": "lvmthin"}}),
+	)
+
+	for _, node := range []string{"pve-1", "pve-2", "pve-3"} {
+		httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/"+node+"/storage/rbd/content",
+			httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": []interface{}{}}),
+		)
+
+		content := []interface{}{}
+		if node == "pve-3" {
+			content = append(content, map[string]interface{}{"volid": "local-lvm:vm-9999-pvc-123", "size": 1024})
+		}
+
+		httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/"+node+"/storage/local-lvm/content",
+			httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": content}),
+		)
+	}
+
+	cl, err := pxapi.NewClient("https://127.0.0.1:8006/api2/json", &http.Client{}, "", nil, "", 600)
+	assert.NoError(t, err)
+
+	cl.SetAPIToken("user!token-id", "secret")
+
+	storageName, node, err := findVolumeNode(context.Background(), cl, newAPICache(time.Minute), "cluster-1", []string{"rbd", "local-lvm"}, nil, "vm-9999-pvc-123", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "local-lvm", storageName)
+	assert.Equal(t, "pve-3", node)
+
+	// The offline node is skipped, the shared storage is checked only once
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 0, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/rbd/content"])
+	assert.Equal(t, 1, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-2/storage/rbd/content"])
+	assert.Equal(t, 0, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-3/storage/rbd/content"])
+	assert.Equal(t, 0, calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-1/storage/local-lvm/content"])
+}
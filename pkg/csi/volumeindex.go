@@ -0,0 +1,427 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+
+	volume "github.com/sergelogvinov/proxmox-csi-plugin/pkg/volume"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// volumeIndexTTL is the period after which the VM configs are read again to check their digests
+	volumeIndexTTL = 30 * time.Second
+	// volumeIndexMissTTL is the period during which a not attached volume is not searched in the VM configs of its node again
+	volumeIndexMissTTL = 30 * time.Second
+)
+
+// volumeAttachment is the VM and the lun of the attached volume.
+type volumeAttachment struct {
+	VM   *pxapi.VmRef
+	Name string
+	Lun  int
+	// Trash is set if the VM holds the deleted volume in the trash
+	Trash bool
+}
+
+type vmVolumes struct {
+	node string
+	name string
+	// digest is the digest of the VM config, the volumes are parsed again only if the config is changed
+	digest        string
+	trash         bool
+	stale         bool
+	checkedAt     time.Time
+	invalidatedAt time.Time
+	// volumes maps the volid (storage:disk) of the plugin volumes to the lun
+	volumes map[string]int
+}
+
+// vmResource is the VM from the /cluster/resources list.
+type vmResource struct {
+	id   int
+	node string
+	name string
+}
+
+// volumeIndex is the cached index of the plugin volumes attached to the VMs of one region.
+// The index is built from the VM list of /cluster/resources and the VM configs, it is keyed on the config digests.
+// The /cluster/resources entry does not change when a disk is attached or detached, so the configs are read again
+// for the VMs changed by the plugin, the moved VMs and the VMs checked more than volumeIndexTTL ago.
+// Found attachments are always checked against the current VM config. If a volume is not found,
+// the configs of the VMs on the volume nodes are read again, so the volumes attached by other controllers are not missed.
+// The not attached volumes are remembered for volumeIndexMissTTL, so repeated lookups do not read the VM configs.
+// The VM configs are read without the lock, so a refresh does not block the other lookups.
+type volumeIndex struct {
+	mu  sync.Mutex
+	vms map[int]*vmVolumes
+	// misses are the times of the node refreshes which did not find the volid
+	misses map[string]time.Time
+}
+
+func newVolumeIndex() *volumeIndex {
+	return &volumeIndex{vms: map[int]*vmVolumes{}, misses: map[string]time.Time{}}
+}
+
+// getVolumeIndex returns the volume index of the region.
+func (d *ControllerService) getVolumeIndex(region string) *volumeIndex {
+	d.volumeIndexLock.Lock()
+	defer d.volumeIndexLock.Unlock()
+
+	if d.volumeIndexes == nil {
+		d.volumeIndexes = map[string]*volumeIndex{}
+	}
+
+	idx, ok := d.volumeIndexes[region]
+	if !ok {
+		idx = newVolumeIndex()
+		d.volumeIndexes[region] = idx
+	}
+
+	return idx
+}
+
+// lookupVolume returns the VMs with the attached volume, see volumeIndex.lookup.
+// The volume on the shared storage can be attached to the VMs on any node of the storage, so they are all read again if it is not found.
+func (d *ControllerService) lookupVolume(ctx context.Context, cl *pxapi.Client, vol *volume.Volume, vmName string) ([]volumeAttachment, error) {
+	storageConfig, err := d.getAPICache(vol.Cluster()).storageConfig(ctx, cl, vol.Storage())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage config: %v", err)
+	}
+
+	nodes := []string{vol.Node()}
+
+	if storageConfig["shared"] != nil && int(storageConfig["shared"].(float64)) == 1 {
+		nodes = nil
+
+		if list, ok := storageConfig["nodes"].(string); ok && list != "" {
+			nodes = strings.Split(list, ",")
+		}
+	}
+
+	return d.getVolumeIndex(vol.Cluster()).lookup(ctx, cl, getVolid(vol), nodes, vmName)
+}
+
+// invalidate marks the VM config as changed, it must be called after the plugin changes the VM config.
+func (idx *volumeIndex) invalidate(vmID int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if vm, ok := idx.vms[vmID]; ok {
+		vm.stale = true
+		vm.invalidatedAt = time.Now()
+	}
+}
+
+// lookup returns the VMs with the attached volume. If vmName is set, only the VM with this name is returned.
+// If the volume is not found, the configs of the VMs on the nodes and of the VM vmName are read again,
+// nil nodes means all nodes.
+func (idx *volumeIndex) lookup(ctx context.Context, cl *pxapi.Client, volid string, nodes []string, vmName string) ([]volumeAttachment, error) {
+	start := time.Now()
+
+	// The found attachments are read again, the volume could be detached by other controllers
+	if err := idx.refresh(ctx, cl, func(_ int, vm *vmVolumes) bool {
+		_, ok := vm.volumes[volid]
+
+		return ok && (vmName == "" || vm.name == vmName) && !vm.checkedAt.After(start)
+	}); err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	attachments := idx.find(volid, vmName)
+
+	if len(attachments) > 0 {
+		delete(idx.misses, volid)
+	}
+
+	missedAt, missed := idx.misses[volid]
+	idx.mu.Unlock()
+
+	// The VMs changed by the plugin are already read again, the volume can be attached only by other controllers
+	if len(attachments) > 0 || (missed && time.Since(missedAt) < volumeIndexMissTTL) {
+		return attachments, nil
+	}
+
+	missedAt = time.Now()
+
+	if err := idx.refresh(ctx, cl, func(_ int, vm *vmVolumes) bool {
+		return (nodes == nil || slices.Contains(nodes, vm.node) || (vmName != "" && vm.name == vmName)) && !vm.checkedAt.After(start)
+	}); err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for id, at := range idx.misses {
+		if missedAt.Sub(at) >= volumeIndexMissTTL {
+			delete(idx.misses, id)
+		}
+	}
+
+	if len(idx.find(volid, "")) == 0 {
+		idx.misses[volid] = missedAt
+	}
+
+	return idx.find(volid, vmName), nil
+}
+
+// attachments returns the names of VMs for all attached volumes, the trash VMs are skipped.
+// The VM configs checked more than volumeIndexTTL ago are read again.
+func (idx *volumeIndex) attachments(ctx context.Context, cl *pxapi.Client) (map[string][]string, error) {
+	if err := idx.refresh(ctx, cl, func(_ int, vm *vmVolumes) bool {
+		return time.Since(vm.checkedAt) > volumeIndexTTL
+	}); err != nil {
+		return nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	res := map[string][]string{}
+
+	for _, vm := range idx.vms {
+		if vm.trash {
+			continue
+		}
+
+		for volid := range vm.volumes {
+			res[volid] = append(res[volid], vm.name)
+		}
+	}
+
+	return res, nil
+}
+
+// find returns the attachments of the volume, the caller must hold the lock.
+func (idx *volumeIndex) find(volid string, vmName string) []volumeAttachment {
+	attachments := []volumeAttachment{}
+
+	for id, vm := range idx.vms {
+		lun, ok := vm.volumes[volid]
+		if !ok || (vmName != "" && vm.name != vmName) {
+			continue
+		}
+
+		vmr := pxapi.NewVmRef(id)
+		vmr.SetNode(vm.node)
+		vmr.SetVmType("qemu")
+
+		attachments = append(attachments, volumeAttachment{VM: vmr, Name: vm.name, Lun: lun, Trash: vm.trash})
+	}
+
+	return attachments
+}
+
+// refresh updates the VM list. The configs are read for the new VMs, the VMs changed by the plugin,
+// the VMs moved to another node and the cached VMs matched by reread.
+// The volumes of the read config are parsed again only if its digest is changed.
+// The reread function is called with the lock held.
+func (idx *volumeIndex) refresh(ctx context.Context, cl *pxapi.Client, reread func(int, *vmVolumes) bool) error {
+	resources, err := getVMResources(cl)
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+
+	fetch := []vmResource{}
+	digests := map[int]string{}
+
+	for _, res := range resources {
+		if cached, ok := idx.vms[res.id]; ok && !cached.stale && cached.node == res.node {
+			if reread == nil || !reread(res.id, cached) {
+				continue
+			}
+
+			digests[res.id] = cached.digest
+		}
+
+		fetch = append(fetch, res)
+	}
+
+	idx.mu.Unlock()
+
+	fetched := make(map[int]*vmVolumes, len(fetch))
+	// unchanged are the check times of the configs with the cached digest
+	unchanged := map[int]time.Time{}
+	failed := map[int]bool{}
+
+	for _, res := range fetch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		vmr := pxapi.NewVmRef(res.id)
+		vmr.SetNode(res.node)
+		vmr.SetVmType("qemu")
+
+		checkedAt := time.Now()
+
+		config, err := cl.GetVmConfig(vmr)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// The node can be offline, the config of the VM is read again next time
+			klog.V(4).Infof("volumeIndex: failed to get vm %d config: %v", res.id, err)
+
+			failed[res.id] = true
+
+			continue
+		}
+
+		digest, _ := config["digest"].(string) //nolint:errcheck
+
+		if digest != "" && digests[res.id] == digest {
+			unchanged[res.id] = checkedAt
+
+			continue
+		}
+
+		fetched[res.id] = &vmVolumes{
+			node:      res.node,
+			name:      res.name,
+			digest:    digest,
+			trash:     isTrashVM(res.name, config),
+			checkedAt: checkedAt,
+			volumes:   getVMVolumes(config),
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	vms := make(map[int]*vmVolumes, len(resources))
+
+	for _, res := range resources {
+		cached, ok := idx.vms[res.id]
+		vm := fetched[res.id]
+
+		switch {
+		case vm != nil && ok && cached.checkedAt.After(vm.checkedAt):
+			// The config was read again by the concurrent refresh
+			vm = cached
+		case vm == nil && ok && !unchanged[res.id].IsZero():
+			vm = cached
+
+			// The config is not changed, unless it was read again by the concurrent refresh
+			if vm.digest == digests[res.id] && vm.checkedAt.Before(unchanged[res.id]) {
+				vm.checkedAt = unchanged[res.id]
+			}
+		case vm != nil && ok && cached.invalidatedAt.After(vm.checkedAt):
+			// The config was changed by the plugin while it was being read
+			vm.stale = true
+			vm.invalidatedAt = cached.invalidatedAt
+		case vm == nil && ok:
+			vm = cached
+			vm.name = res.name
+
+			if failed[res.id] {
+				vm.stale = true
+			}
+		case vm == nil:
+			continue
+		}
+
+		vms[res.id] = vm
+	}
+
+	idx.vms = vms
+
+	return nil
+}
+
+// getVMResources returns the qemu VMs from the /cluster/resources list.
+func getVMResources(cl *pxapi.Client) ([]vmResource, error) {
+	vms, err := cl.GetResourceList("vm")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vm list: %v", err)
+	}
+
+	resources := make([]vmResource, 0, len(vms))
+
+	for _, item := range vms {
+		vm, ok := item.(map[string]interface{})
+		if !ok || vm["type"] != "qemu" {
+			continue
+		}
+
+		vmid, ok := vm["vmid"].(float64)
+		if !ok {
+			continue
+		}
+
+		node, _ := vm["node"].(string) //nolint:errcheck
+		name, _ := vm["name"].(string) //nolint:errcheck
+
+		resources = append(resources, vmResource{
+			id:   int(vmid),
+			node: node,
+			name: name,
+		})
+	}
+
+	return resources, nil
+}
+
+// getVolid returns the Proxmox volume ID of the volume, as it is in the VM config.
+func getVolid(vol *volume.Volume) string {
+	return vol.Storage() + ":" + vol.Disk()
+}
+
+func getVolidFromVolumeID(volumeID string) string {
+	vol, err := volume.NewVolumeFromVolumeID(volumeID)
+	if err != nil {
+		return ""
+	}
+
+	return getVolid(vol)
+}
+
+// getVMVolumes returns the plugin volumes attached to the VM.
+func getVMVolumes(vmConfig map[string]interface{}) map[string]int {
+	volumes := map[string]int{}
+
+	for lun := 1; lun < 30; lun++ {
+		device, ok := vmConfig[deviceNamePrefix+strconv.Itoa(lun)].(string)
+		if !ok {
+			continue
+		}
+
+		volid := strings.SplitN(device, ",", 2)[0]
+
+		if parts := strings.SplitN(volid, ":", 2); len(parts) == 2 && strings.HasPrefix(path.Base(parts[1]), fmt.Sprintf("vm-%d-", vmID)) {
+			volumes[volid] = lun
+		}
+	}
+
+	return volumes
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetVMVolumes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg      string
+		vmConfig map[string]interface{}
+		expected map[string]int
+	}{
+		{
+			msg:      "Empty VM config",
+			vmConfig: map[string]interface{}{},
+			expected: map[string]int{},
+		},
+		{
+			msg: "Volumes",
+			vmConfig: map[string]interface{}{
+				"scsi0": "local-lvm:vm-9999-pvc-root,size=10G",
+				"scsi1": "local-lvm:vm-9999-pvc-1,backup=0,iothread=1,wwn=0x5056432d49443031",
+				"scsi2": "local-lvm:vm-100-disk-1,size=10G",
+				"scsi3": "local:9999/vm-9999-pvc-123.qcow2,backup=0",
+				"scsi4": "rbd:vm-9999-pvc-123,backup=0",
+				"ide2":  "local-lvm:vm-9999-pvc-cdrom,media=cdrom",
+			},
+			expected: map[string]int{
+				"local-lvm:vm-9999-pvc-1":          1,
+				"local:9999/vm-9999-pvc-123.qcow2": 3,
+				"rbd:vm-9999-pvc-123":              4,
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, getVMVolumes(testCase.vmConfig))
+		})
+	}
+}
+
+func TestIsTrashVM(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg      string
+		name     string
+		vmConfig map[string]interface{}
+		expected bool
+	}{
+		{
+			msg:  "TrashVM",
+			name: "csi-trash-110",
+			vmConfig: map[string]interface{}{
+				"description": "Volume cluster-1/pve-1/local-lvm/vm-9999-pvc-123 is deleted by csi.proxmox.sinextra.dev at 2024-01-02T03:04:05Z, it is kept in the trash.",
+			},
+			expected: true,
+		},
+		{
+			msg:      "UserVMWithTrashName",
+			name:     "csi-trash-app",
+			vmConfig: map[string]interface{}{"description": "my app"},
+		},
+		{
+			msg:  "UserVMWithTrashDescription",
+			name: "cluster-1-node-1",
+			vmConfig: map[string]interface{}{
+				"description": "Volume cluster-1/pve-1/local-lvm/vm-9999-pvc-123 is deleted by csi.proxmox.sinextra.dev, it is kept in the trash.",
+			},
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, isTrashVM(testCase.name, testCase.vmConfig))
+		})
+	}
+}
+
+func TestVolumeIndexRefresh(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	resources := []interface{}{
+		map[string]interface{}{"node": "pve-1", "type": "qemu", "vmid": 100, "name": "node-1", "status": "running"},
+		map[string]interface{}{"node": "pve-2", "type": "qemu", "vmid": 101, "name": "node-2", "status": "running"},
+	}
+	config := map[string]interface{}{"digest": "1", "scsi1": "local-lvm:vm-9999-pvc-123,backup=0"}
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/cluster/resources",
+		func(_ *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": resources})
+		},
+	)
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config",
+		func(_ *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": config})
+		},
+	)
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/nodes/pve-2/qemu/101/config",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": map[string]interface{}{"digest": "2"}}),
+	)
+
+	cl, err := pxapi.NewClient("https://127.0.0.1:8006/api2/json", &http.Client{}, "", nil, "", 600)
+	assert.NoError(t, err)
+
+	cl.SetAPIToken("user!token-id", "secret")
+
+	ctx := context.Background()
+	idx := newVolumeIndex()
+
+	configCalls := func() []int {
+		calls := httpmock.GetCallCountInfo()
+
+		return []int{
+			calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config"],
+			calls["GET https://127.0.0.1:8006/api2/json/nodes/pve-2/qemu/101/config"],
+		}
+	}
+
+	attachments, err := idx.attachments(ctx, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"local-lvm:vm-9999-pvc-123": {"node-1"}}, attachments)
+
+	// The VMs checked recently are not read again
+	_, err = idx.attachments(ctx, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 1}, configCalls())
+
+	// The VM changed by the plugin is read again
+	idx.invalidate(100)
+
+	_, err = idx.attachments(ctx, cl)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, configCalls())
+
+	// The volume detached by others does not change the /cluster/resources entry,
+	// it is found by the config digest after volumeIndexTTL
+	config = map[string]interface{}{"digest": "3"}
+
+	idx.mu.Lock()
+	for _, vm := range idx.vms {
+		vm.checkedAt = vm.checkedAt.Add(-volumeIndexTTL - time.Second)
+	}
+	idx.mu.Unlock()
+
+	attachments, err = idx.attachments(ctx, cl)
+	assert.NoError(t, err)
+	assert.Empty(t, attachments)
+	assert.Equal(t, []int{3, 2}, configCalls())
+	assert.Equal(t, "2", idx.vms[101].digest)
+
+	// The not attached volume reads again only the VMs on its node, and only once
+	for i := 0; i < 2; i++ {
+		found, err := idx.lookup(ctx, cl, "local-lvm:vm-9999-pvc-none", []string{"pve-1"}, "")
+		assert.NoError(t, err)
+		assert.Empty(t, found)
+	}
+
+	assert.Equal(t, []int{4, 2}, configCalls())
+
+	// The volume of the shared storage is searched in the VMs on all nodes
+	config = map[string]interface{}{"digest": "4", "scsi2": "rbd:vm-9999-pvc-shared,backup=0"}
+
+	found, err := idx.lookup(ctx, cl, "rbd:vm-9999-pvc-shared", nil, "")
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, 2, found[0].Lun)
+	assert.Equal(t, []int{5, 3}, configCalls())
+
+	// The found attachment is checked against the current VM config
+	found, err = idx.lookup(ctx, cl, "rbd:vm-9999-pvc-shared", nil, "")
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, []int{6, 3}, configCalls())
+}
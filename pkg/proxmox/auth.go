@@ -0,0 +1,187 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ticketRenewal is the age of the ticket after which a new one is requested, Proxmox tickets are valid for 2 hours
+	ticketRenewal = time.Hour
+
+	authCookieName = "PVEAuthCookie"
+	csrfHeaderName = "CSRFPreventionToken"
+)
+
+type ticket struct {
+	value    string
+	csrf     string
+	issuedAt time.Time
+}
+
+// ticketAuth authenticates the requests with the ticket of the user, the ticket is requested on the first request and renewed by age.
+// The write requests also send the CSRF prevention token of the ticket.
+type ticketAuth struct {
+	url      string
+	region   string
+	username string
+	password string
+
+	// lock allows one login at a time, the channel is used so that waiting requests can be cancelled
+	lock   chan struct{}
+	ticket *ticket
+}
+
+func newTicketAuth(cfg *ClusterConfig) *ticketAuth {
+	return &ticketAuth{
+		url:      strings.TrimSuffix(cfg.Endpoints()[0], "/"),
+		region:   cfg.Region,
+		username: cfg.Username,
+		password: cfg.Password,
+		lock:     make(chan struct{}, 1),
+	}
+}
+
+// roundTrip sends the request with the ticket. If Proxmox rejects the ticket, the request is sent once again with a new ticket.
+func (a *ticketAuth) roundTrip(t *contextTransport, base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	tk, err := a.get(t, base, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.retry(base, a.authorize(req, tk))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	resp.Body.Close() //nolint:errcheck
+
+	klog.V(4).Infof("proxmox: the ticket of %s was rejected by cluster %s, logging in again", a.username, a.region)
+
+	if tk, err = a.get(t, base, tk); err != nil {
+		return nil, err
+	}
+
+	r := a.authorize(req, tk)
+
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.retry(base, r)
+}
+
+// authorize returns the copy of the request with the ticket.
+func (a *ticketAuth) authorize(req *http.Request, tk *ticket) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Del("Authorization")
+	r.AddCookie(&http.Cookie{Name: authCookieName, Value: tk.value})
+
+	if !isIdempotent(req.Method) {
+		r.Header.Set(csrfHeaderName, tk.csrf)
+	}
+
+	return r
+}
+
+// get returns the valid ticket, a new ticket is requested if the current one is too old or it is the rejected one.
+func (a *ticketAuth) get(t *contextTransport, base http.RoundTripper, rejected *ticket) (*ticket, error) {
+	select {
+	case a.lock <- struct{}{}:
+	case <-t.ctx.Done():
+		return nil, t.ctx.Err()
+	}
+	defer func() { <-a.lock }()
+
+	if a.ticket != nil && a.ticket != rejected && time.Since(a.ticket.issuedAt) < ticketRenewal {
+		return a.ticket, nil
+	}
+
+	tk, err := a.login(t, base)
+	if err != nil {
+		return nil, err
+	}
+
+	addSecret(tk.value, tk.csrf)
+
+	if a.ticket == nil {
+		klog.V(2).Infof("proxmox: logged in to cluster %s as %s", a.region, a.username)
+	} else {
+		removeSecret(a.ticket.value, a.ticket.csrf)
+
+		klog.V(4).Infof("proxmox: renewed the ticket of %s for cluster %s", a.username, a.region)
+	}
+
+	a.ticket = tk
+
+	return tk, nil
+}
+
+// login requests a new ticket. The error never contains the password or the ticket.
+func (a *ticketAuth) login(t *contextTransport, base http.RoundTripper) (*ticket, error) {
+	form := url.Values{"username": {a.username}, "password": {a.password}}
+
+	req, err := http.NewRequestWithContext(t.ctx, http.MethodPost, a.url+"/access/ticket", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: %v", a.region, a.username, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.retry(base, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: %v", a.region, a.username, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: %s", a.region, a.username, resp.Status)
+	}
+
+	var data struct {
+		Data struct {
+			Ticket  string `json:"ticket"`
+			CSRF    string `json:"CSRFPreventionToken"`
+			NeedTFA int    `json:"NeedTFA"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: invalid response: %v", a.region, a.username, err)
+	}
+
+	if data.Data.NeedTFA == 1 {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: two-factor authentication is not supported", a.region, a.username)
+	}
+
+	if data.Data.Ticket == "" {
+		return nil, fmt.Errorf("failed to login to cluster %s as %s: no ticket in the response", a.region, a.username)
+	}
+
+	return &ticket{value: data.Data.Ticket, csrf: data.Data.CSRF, issuedAt: time.Now()}, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func newPasswordCluster(t *testing.T) *proxmox.Cluster {
+	t.Helper()
+
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    username: "kubernetes-csi@pve"
+    password: "login-password"
+    region: cluster-1
+`))
+	assert.Nil(t, err)
+
+	client, err := proxmox.NewCluster(&cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	return client
+}
+
+func registerLogin(t *testing.T, tickets ...string) {
+	t.Helper()
+
+	logins := 0
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/access/ticket",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Nil(t, req.ParseForm())
+			assert.Equal(t, "kubernetes-csi@pve", req.PostForm.Get("username"))
+
+			if req.PostForm.Get("password") != "login-password" {
+				resp := httpmock.NewStringResponse(401, "")
+				resp.Status = "401 authentication failure"
+
+				return resp, nil
+			}
+
+			ticket := tickets[min(logins, len(tickets)-1)]
+			logins++
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{
+					"username":            "kubernetes-csi@pve",
+					"ticket":              ticket,
+					"CSRFPreventionToken": "csrf-" + ticket,
+				},
+			})
+		},
+	)
+}
+
+func TestTicketAuth(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	registerLogin(t, "PVE:kubernetes-csi@pve:1::ticket")
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			cookie, err := req.Cookie("PVEAuthCookie")
+			assert.Nil(t, err)
+			assert.Equal(t, "PVE:kubernetes-csi@pve:1::ticket", cookie.Value)
+			assert.Empty(t, req.Header.Get("CSRFPreventionToken"))
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}})
+		},
+	)
+
+	httpmock.RegisterResponder("PUT", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/unlink",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "csrf-PVE:kubernetes-csi@pve:1::ticket", req.Header.Get("CSRFPreventionToken"))
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": nil})
+		},
+	)
+
+	client := newPasswordCluster(t)
+
+	// The user logs in with the first request
+	assert.Equal(t, 0, httpmock.GetTotalCallCount())
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+
+	err = cl.Put(map[string]interface{}{"idlist": "scsi1"}, "/nodes/pve-1/qemu/100/unlink")
+	assert.Nil(t, err)
+
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, calls["POST https://127.0.0.1:8006/api2/json/access/ticket"])
+
+	assert.Equal(t, "ticket <redacted>, password <redacted>", proxmox.Redact("ticket PVE:kubernetes-csi@pve:1::ticket, password login-password"))
+}
+
+func TestTicketAuthRenew(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	registerLogin(t, "ticket-1", "ticket-2")
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			// The first ticket is expired
+			if cookie, err := req.Cookie("PVEAuthCookie"); err != nil || cookie.Value != "ticket-2" {
+				return httpmock.NewStringResponse(401, ""), nil
+			}
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}})
+		},
+	)
+
+	client := newPasswordCluster(t)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 2, calls["POST https://127.0.0.1:8006/api2/json/access/ticket"])
+	assert.Equal(t, 2, calls["GET https://127.0.0.1:8006/api2/json/version"])
+}
+
+func TestTicketAuthFailed(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	registerLogin(t, "ticket")
+
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    username: "kubernetes-csi@pve"
+    password: "wrong-password"
+    region: cluster-1
+`))
+	assert.Nil(t, err)
+
+	client, err := proxmox.NewCluster(&cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to login to cluster cluster-1 as kubernetes-csi@pve: 401 authentication failure")
+	assert.ErrorIs(t, proxmox.ParseError(err), proxmox.ErrPermissionDenied)
+	assert.NotContains(t, err.Error(), "wrong-password")
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxmox implements the Proxmox API clients of the regions.
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+
+	pxapi "github.com/Telmate/proxmox-api-go/proxmox"
+)
+
+const (
+	// taskTimeout is the timeout in seconds of Proxmox tasks, the request context can stop the wait earlier
+	taskTimeout = 600
+)
+
+// Cluster is the Proxmox API clients of the regions.
+type Cluster struct {
+	hclient *http.Client
+
+	mu      sync.RWMutex
+	regions []string
+	clients map[string]*regionClient
+}
+
+type regionClient struct {
+	config      ClusterConfig
+	url         string
+	tls         *tls.Config
+	transport   http.RoundTripper
+	tokenID     string
+	tokenSecret string
+	breaker     *circuitBreaker
+	limiter     *limiter
+	endpoints   *endpointSet
+
+	// auth is the ticket authentication of the user, nil if the API token is used
+	auth *ticketAuth
+}
+
+// NewCluster creates a new Proxmox cluster client.
+// If hclient is nil, the HTTP transport is created with the TLS options of the region.
+func NewCluster(config *ClustersConfig, hclient *http.Client) (*Cluster, error) {
+	c := &Cluster{hclient: hclient}
+
+	if err := c.Update(config); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Update applies the new config. The clients of the changed regions are replaced, the unchanged regions keep their clients with the tickets.
+// The requests in flight are finished by the old clients.
+func (c *Cluster) Update(config *ClustersConfig) error {
+	if len(config.Clusters) == 0 {
+		return fmt.Errorf("no Proxmox clusters found")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	regions := make([]string, 0, len(config.Clusters))
+	clients := make(map[string]*regionClient, len(config.Clusters))
+
+	for _, cfg := range config.Clusters {
+		regions = append(regions, cfg.Region)
+
+		if rc, ok := c.clients[cfg.Region]; ok && reflect.DeepEqual(rc.config, cfg) {
+			clients[cfg.Region] = rc
+
+			continue
+		}
+
+		clients[cfg.Region] = c.newRegionClient(cfg)
+	}
+
+	for region, rc := range c.clients {
+		if clients[region] != rc {
+			c.closeRegionClient(rc)
+		}
+
+		if _, ok := clients[region]; !ok {
+			endpointsVar.Delete(region)
+		}
+	}
+
+	c.regions = regions
+	c.clients = clients
+
+	return nil
+}
+
+// Regions returns the regions in the order of the config.
+func (c *Cluster) Regions() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.regions)
+}
+
+func (c *Cluster) newRegionClient(cfg ClusterConfig) *regionClient {
+	tlsconf := newTLSConfig(&cfg)
+
+	var transport http.RoundTripper
+
+	if c.hclient != nil {
+		transport = c.hclient.Transport
+	} else {
+		transport = &http.Transport{
+			TLSClientConfig:    tlsconf,
+			DisableCompression: true,
+			Proxy:              nil,
+		}
+	}
+
+	addSecret(cfg.TokenSecret, cfg.Password)
+
+	rc := &regionClient{
+		config:      cfg,
+		url:         cfg.Endpoints()[0],
+		tls:         tlsconf,
+		transport:   transport,
+		tokenID:     cfg.TokenID,
+		tokenSecret: cfg.TokenSecret,
+		breaker:     newCircuitBreaker(cfg.Region),
+		limiter:     newLimiter(&cfg),
+		endpoints:   newEndpointSet(cfg.Region, cfg.Endpoints()),
+	}
+
+	rc.endpoints.start(transport)
+
+	// The user logs in with the first request, so the unavailable cluster does not fail the start
+	if rc.tokenID == "" {
+		rc.auth = newTicketAuth(&cfg)
+	}
+
+	return rc
+}
+
+// closeRegionClient stops the health checks and closes the idle connections of the replaced client, the requests in flight are not affected.
+func (c *Cluster) closeRegionClient(rc *regionClient) {
+	rc.endpoints.close()
+
+	if t, ok := rc.transport.(*http.Transport); ok && c.hclient == nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// GetProxmoxCluster returns a Proxmox client of the region, all requests of the client are bound to the context.
+// The client must not be used after the context is done.
+func (c *Cluster) GetProxmoxCluster(ctx context.Context, region string) (*pxapi.Client, error) {
+	c.mu.RLock()
+	rc, ok := c.clients[region]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("proxmox cluster %s not found", region)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	client, err := rc.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if rc.auth == nil {
+		client.SetAPIToken(rc.tokenID, rc.tokenSecret)
+	}
+
+	return client, nil
+}
+
+func (rc *regionClient) newClient(ctx context.Context) (*pxapi.Client, error) {
+	hclient := &http.Client{Transport: &contextTransport{ctx: ctx, base: rc.transport, breaker: rc.breaker, limiter: rc.limiter, endpoints: rc.endpoints, auth: rc.auth}}
+
+	return pxapi.NewClient(rc.url, hclient, os.Getenv("PM_HTTP_HEADERS"), rc.tls, "", taskTimeout)
+}
+
+// contextTransport binds the requests to the context, pxapi does not support contexts.
+type contextTransport struct {
+	ctx       context.Context //nolint:containedctx
+	base      http.RoundTripper
+	breaker   *circuitBreaker
+	limiter   *limiter
+	endpoints *endpointSet
+	auth      *ticketAuth
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	req = req.WithContext(t.ctx)
+
+	if t.auth != nil {
+		return t.auth.roundTrip(t, base, req)
+	}
+
+	return t.retry(base, req)
+}
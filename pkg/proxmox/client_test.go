@@ -0,0 +1,168 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func newClusterEnv() (*proxmox.ClustersConfig, error) {
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    insecure: false
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+  - url: https://127.0.0.2:8006/api2/json
+    insecure: false
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-2
+`))
+
+	return &cfg, err
+}
+
+func TestNewCluster(t *testing.T) {
+	cfg, err := newClusterEnv()
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+
+	client, err := proxmox.NewCluster(&proxmox.ClustersConfig{}, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, client)
+
+	client, err = proxmox.NewCluster(cfg, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "test")
+	assert.NotNil(t, err)
+	assert.Nil(t, cl)
+	assert.Equal(t, "proxmox cluster test not found", err.Error())
+
+	cl, err = client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+	assert.NotNil(t, cl)
+}
+
+func TestGetProxmoxClusterContext(t *testing.T) {
+	cfg, err := newClusterEnv()
+	assert.Nil(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "PVEAPIToken=user!token-id=secret", req.Header.Get("Authorization"))
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{"version": "8.1.3"},
+			})
+		},
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+
+			return nil, req.Context().Err()
+		},
+	)
+
+	client, err := proxmox.NewCluster(cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	version, err := cl.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "8.1.3"}, version["data"])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cl, err = client.GetProxmoxCluster(ctx, "cluster-2")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = client.GetProxmoxCluster(ctx, "cluster-2")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestClusterUpdate(t *testing.T) {
+	cfg, err := newClusterEnv()
+	assert.Nil(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(200, map[string]interface{}{
+				"data": map[string]interface{}{"version": req.Header.Get("Authorization")},
+			})
+		},
+	)
+
+	client, err := proxmox.NewCluster(cfg, &http.Client{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, client.Regions())
+
+	old, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	err = client.Update(&proxmox.ClustersConfig{})
+	assert.NotNil(t, err)
+
+	err = client.Update(&proxmox.ClustersConfig{Clusters: []proxmox.ClusterConfig{
+		{URL: "https://127.0.0.1:8006/api2/json", TokenID: "user!token-id", TokenSecret: "rotated", Region: "cluster-1"},
+		{URL: "https://127.0.0.3:8006/api2/json", TokenID: "user!token-id", TokenSecret: "secret", Region: "cluster-3"},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-3"}, client.Regions())
+
+	_, err = client.GetProxmoxCluster(context.Background(), "cluster-2")
+	assert.EqualError(t, err, "proxmox cluster cluster-2 not found")
+
+	// The client of the request in flight keeps the old secret
+	version, err := old.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "PVEAPIToken=user!token-id=secret"}, version["data"])
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	version, err = cl.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "PVEAPIToken=user!token-id=rotated"}, version["data"])
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ClustersConfig is proxmox multi-cluster cloud config.
+type ClustersConfig struct {
+	Clusters []ClusterConfig `yaml:"clusters,omitempty"`
+}
+
+// ClusterConfig is the config of one Proxmox cluster (region).
+type ClusterConfig struct {
+	URL      string `yaml:"url"`
+	Insecure bool   `yaml:"insecure,omitempty"`
+	// URLs are the API endpoints of the nodes of the cluster, instead of URL.
+	// The first available one is used, the requests are moved to the next one if the endpoint is not available.
+	URLs        []string `yaml:"urls,omitempty"`
+	TokenID     string   `yaml:"token_id,omitempty"`
+	TokenSecret string   `yaml:"token_secret,omitempty"`
+	Username    string   `yaml:"username,omitempty"`
+	Password    string   `yaml:"password,omitempty"`
+	Region      string   `yaml:"region,omitempty"`
+
+	// TokenSecretFile and PasswordFile are the files with the secrets, for example the mounted Kubernetes secrets
+	TokenSecretFile string `yaml:"token_secret_file,omitempty"`
+	PasswordFile    string `yaml:"password_file,omitempty"`
+
+	// CAFile is the PEM bundle of the certificate authorities of the Proxmox API, instead of the system ones
+	CAFile string `yaml:"ca_file,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the Proxmox API certificate, the other certificates are rejected.
+	// The hex digits can be separated by colons, as Proxmox shows them.
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+	// Fingerprints are the fingerprints of the certificates of the nodes in urls, instead of Fingerprint.
+	// Each Proxmox node has its own certificate, the certificate must match one of them.
+	Fingerprints []string `yaml:"fingerprints,omitempty"`
+
+	// RateLimit is the maximum number of requests per second to the Proxmox API, unlimited if zero
+	RateLimit float64 `yaml:"rate_limit,omitempty"`
+	// RateBurst is the number of requests sent without waiting, defaults to the rate limit
+	RateBurst int `yaml:"rate_burst,omitempty"`
+	// MaxConcurrency is the maximum number of the concurrent requests to the Proxmox API, unlimited if zero
+	MaxConcurrency int `yaml:"max_concurrency,omitempty"`
+
+	// caData is the content of CAFile, so the changed file is detected on reload
+	caData []byte
+}
+
+// ReadCloudConfig reads cloud config from a reader.
+func ReadCloudConfig(config io.Reader) (ClustersConfig, error) {
+	cfg := ClustersConfig{}
+
+	if config != nil {
+		if err := yaml.NewDecoder(config).Decode(&cfg); err != nil {
+			return ClustersConfig{}, err
+		}
+	}
+
+	for idx := range cfg.Clusters {
+		if err := readSecretFiles(&cfg.Clusters[idx]); err != nil {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: %v", idx+1, err)
+		}
+
+		if err := readTLSFiles(&cfg.Clusters[idx]); err != nil {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: %v", idx+1, err)
+		}
+
+		c := cfg.Clusters[idx]
+
+		if c.Username != "" && c.Password != "" {
+			if c.TokenID != "" || c.TokenSecret != "" {
+				return ClustersConfig{}, fmt.Errorf("cluster #%d: token_id and token_secret are not allowed when username and password are set", idx+1)
+			}
+		} else if c.TokenID == "" || c.TokenSecret == "" {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: either username and password or token_id and token_secret are required", idx+1)
+		}
+
+		if c.RateLimit < 0 || c.RateBurst < 0 || c.MaxConcurrency < 0 {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: rate_limit, rate_burst and max_concurrency must not be negative", idx+1)
+		}
+
+		if c.Region == "" {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: region is required", idx+1)
+		}
+
+		if c.URL != "" && len(c.URLs) > 0 {
+			return ClustersConfig{}, fmt.Errorf("cluster #%d: url and urls are mutually exclusive", idx+1)
+		}
+
+		for _, u := range c.Endpoints() {
+			if u == "" || !strings.HasPrefix(u, "http") {
+				return ClustersConfig{}, fmt.Errorf("cluster #%d: url is required", idx+1)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Endpoints returns the API endpoints of the cluster in the order of preference.
+func (c *ClusterConfig) Endpoints() []string {
+	if len(c.URLs) > 0 {
+		return c.URLs
+	}
+
+	return []string{c.URL}
+}
+
+// readSecretFiles reads the secrets from the files, the surrounding whitespace is removed.
+func readSecretFiles(c *ClusterConfig) error {
+	if c.TokenSecretFile != "" {
+		if c.TokenSecret != "" {
+			return fmt.Errorf("token_secret and token_secret_file are mutually exclusive")
+		}
+
+		data, err := os.ReadFile(filepath.Clean(c.TokenSecretFile))
+		if err != nil {
+			return fmt.Errorf("failed to read token_secret_file: %v", err)
+		}
+
+		c.TokenSecret = strings.TrimSpace(string(data))
+	}
+
+	if c.PasswordFile != "" {
+		if c.Password != "" {
+			return fmt.Errorf("password and password_file are mutually exclusive")
+		}
+
+		data, err := os.ReadFile(filepath.Clean(c.PasswordFile))
+		if err != nil {
+			return fmt.Errorf("failed to read password_file: %v", err)
+		}
+
+		c.Password = strings.TrimSpace(string(data))
+	}
+
+	return nil
+}
+
+// ReadCloudConfigFromFile reads cloud config from a file.
+func ReadCloudConfigFromFile(file string) (ClustersConfig, error) {
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return ClustersConfig{}, fmt.Errorf("error reading %s: %v", file, err)
+	}
+	defer f.Close() // nolint: errcheck
+
+	return ReadCloudConfig(f)
+}
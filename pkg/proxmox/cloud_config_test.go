@@ -0,0 +1,161 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	proxmox "github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestReadCloudConfig(t *testing.T) {
+	cfg, err := proxmox.ReadCloudConfig(nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+
+	// Empty config
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+`))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+
+	// Wrong config
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  test: false
+`))
+
+	assert.NotNil(t, err)
+	assert.NotNil(t, cfg)
+
+	// Non full config
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+- url: abcd
+  region: cluster-1
+`))
+
+	assert.NotNil(t, err)
+	assert.NotNil(t, cfg)
+
+	// Valid config with one cluster
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    insecure: false
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+`))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+	assert.Equal(t, 1, len(cfg.Clusters))
+
+	// Valid config with one cluster (username/password)
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    insecure: false
+    username: "user@pam"
+    password: "secret"
+    region: cluster-1
+`))
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+	assert.Equal(t, 1, len(cfg.Clusters))
+
+	// Valid config with the API limits
+	cfg, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+    rate_limit: 2.5
+    rate_burst: 5
+    max_concurrency: 4
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.5, cfg.Clusters[0].RateLimit)
+	assert.Equal(t, 5, cfg.Clusters[0].RateBurst)
+	assert.Equal(t, 4, cfg.Clusters[0].MaxConcurrency)
+
+	// Negative API limits
+	_, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+    max_concurrency: -1
+`))
+	assert.NotNil(t, err)
+}
+
+func TestReadCloudConfigFromFile(t *testing.T) {
+	cfg, err := proxmox.ReadCloudConfigFromFile("testdata/cloud-config.yaml")
+	assert.NotNil(t, err)
+	assert.EqualError(t, err, "error reading testdata/cloud-config.yaml: open testdata/cloud-config.yaml: no such file or directory")
+	assert.NotNil(t, cfg)
+
+	cfg, err = proxmox.ReadCloudConfigFromFile("../../hack/testdata/cloud-config.yaml")
+	assert.Nil(t, err)
+	assert.NotNil(t, cfg)
+	assert.Equal(t, 2, len(cfg.Clusters))
+}
+
+func TestReadCloudConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "token_secret")
+	assert.Nil(t, os.WriteFile(secretFile, []byte("file-secret\n"), 0o600))
+
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "user!token-id"
+    token_secret_file: ` + secretFile + `
+    region: cluster-1
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "file-secret", cfg.Clusters[0].TokenSecret)
+
+	_, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    token_id: "user!token-id"
+    token_secret: "secret"
+    token_secret_file: ` + secretFile + `
+    region: cluster-1
+`))
+	assert.EqualError(t, err, "cluster #1: token_secret and token_secret_file are mutually exclusive")
+
+	_, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://example.com
+    username: "user@pam"
+    password_file: ` + filepath.Join(dir, "password") + `
+    region: cluster-1
+`))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cluster #1: failed to read password_file")
+}
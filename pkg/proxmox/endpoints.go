@@ -0,0 +1,207 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// healthCheckInterval is the period of the health checks of the endpoints
+	healthCheckInterval = 30 * time.Second
+	// healthCheckTimeout is the timeout of one health check request
+	healthCheckTimeout = 5 * time.Second
+)
+
+// endpointsVar is the active endpoint and the health of the endpoints of the regions, it is published as proxmox_endpoints in expvar.
+var endpointsVar = expvar.NewMap("proxmox_endpoints")
+
+type endpoint struct {
+	url *url.URL
+	up  bool
+}
+
+// endpointSet is the API endpoints of one region. The requests are sent to the first healthy endpoint,
+// the endpoint is marked down when it does not respond, and the health checks bring it back.
+type endpointSet struct {
+	region string
+	// primary is the URL the pxapi client is created with, the requests are moved from it to the active endpoint
+	primary *url.URL
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	active    *endpoint
+
+	stop chan struct{}
+}
+
+func newEndpointSet(region string, urls []string) *endpointSet {
+	s := &endpointSet{region: region}
+
+	for _, u := range urls {
+		parsed, err := url.Parse(strings.TrimSuffix(u, "/"))
+		if err != nil {
+			// The URL is checked by ReadCloudConfig, the invalid one is kept as is and the requests to it fail
+			parsed = &url.URL{Path: u}
+		}
+
+		s.endpoints = append(s.endpoints, &endpoint{url: parsed, up: true})
+	}
+
+	s.primary = s.endpoints[0].url
+	s.active = s.endpoints[0]
+
+	endpointsVar.Set(region, expvar.Func(s.status))
+
+	return s
+}
+
+// start runs the health checks of the endpoints, if the region has many of them.
+func (s *endpointSet) start(base http.RoundTripper) {
+	if len(s.endpoints) < 2 {
+		return
+	}
+
+	s.stop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.check(base)
+			}
+		}
+	}()
+}
+
+// close stops the health checks, it is called when the region client is replaced.
+func (s *endpointSet) close() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+}
+
+// check sends the health check request to every endpoint.
+// Any response shows that the endpoint is up, the unauthorized one too.
+func (s *endpointSet) check(base http.RoundTripper) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	s.mu.Lock()
+	endpoints := append([]*endpoint{}, s.endpoints...)
+	s.mu.Unlock()
+
+	for _, ep := range endpoints {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+
+		up := false
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url.String()+"/version", nil)
+		if err == nil {
+			resp, err := base.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close() //nolint:errcheck
+			}
+
+			up = !isUnavailable(resp, err)
+		}
+
+		cancel()
+
+		s.setHealth(ep, up)
+	}
+}
+
+// get returns the active endpoint and the request moved to it.
+func (s *endpointSet) get(req *http.Request) (*endpoint, *http.Request) {
+	s.mu.Lock()
+	ep := s.active
+	s.mu.Unlock()
+
+	if ep.url == s.primary {
+		return ep, req
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.Scheme = ep.url.Scheme
+	r.URL.Host = ep.url.Host
+	r.URL.Path = ep.url.Path + strings.TrimPrefix(req.URL.Path, s.primary.Path)
+	r.URL.RawPath = ""
+	r.Host = ep.url.Host
+
+	return ep, r
+}
+
+// setHealth updates the health of the endpoint, the first healthy endpoint becomes the active one.
+// If all endpoints are down, the active one is kept.
+func (s *endpointSet) setHealth(ep *endpoint, up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ep.up == up {
+		return
+	}
+
+	ep.up = up
+
+	if !up {
+		klog.Warningf("proxmox: endpoint %s of cluster %s is unavailable", ep.url.Host, s.region)
+	} else {
+		klog.Infof("proxmox: endpoint %s of cluster %s is available", ep.url.Host, s.region)
+	}
+
+	for _, e := range s.endpoints {
+		if e.up {
+			if e != s.active {
+				klog.Infof("proxmox: cluster %s uses endpoint %s", s.region, e.url.Host)
+
+				s.active = e
+			}
+
+			return
+		}
+	}
+}
+
+func (s *endpointSet) status() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make(map[string]bool, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		endpoints[ep.url.String()] = ep.up
+	}
+
+	return map[string]interface{}{
+		"active":    s.active.url.String(),
+		"endpoints": endpoints,
+	}
+}
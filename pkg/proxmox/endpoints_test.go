@@ -0,0 +1,123 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "PVEAPIToken=user!token-id=secret", req.Header.Get("Authorization"))
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}})
+		},
+	)
+
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - urls:
+      - https://127.0.0.1:8006/api2/json
+      - https://127.0.0.2:8006/api2/json
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-failover
+`))
+	assert.Nil(t, err)
+
+	client, err := proxmox.NewCluster(&cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		cl, err := client.GetProxmoxCluster(context.Background(), "cluster-failover")
+		assert.Nil(t, err)
+
+		_, err = cl.GetVersion()
+		assert.Nil(t, err)
+	}
+
+	// The unavailable endpoint is used once
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, calls["GET https://127.0.0.1:8006/api2/json/version"])
+	assert.Equal(t, 2, calls["GET https://127.0.0.2:8006/api2/json/version"])
+
+	var status map[string]struct {
+		Active    string          `json:"active"`
+		Endpoints map[string]bool `json:"endpoints"`
+	}
+
+	assert.Nil(t, json.Unmarshal([]byte(expvar.Get("proxmox_endpoints").String()), &status))
+	assert.Equal(t, "https://127.0.0.2:8006/api2/json", status["cluster-failover"].Active)
+	assert.Equal(t, map[string]bool{
+		"https://127.0.0.1:8006/api2/json": false,
+		"https://127.0.0.2:8006/api2/json": true,
+	}, status["cluster-failover"].Endpoints)
+}
+
+func TestReadCloudConfigURLs(t *testing.T) {
+	_, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    urls:
+      - https://127.0.0.2:8006/api2/json
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+`))
+	assert.EqualError(t, err, "cluster #1: url and urls are mutually exclusive")
+
+	_, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - urls:
+      - https://127.0.0.1:8006/api2/json
+      - 127.0.0.2
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+`))
+	assert.EqualError(t, err, "cluster #1: url is required")
+
+	_, err = proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - urls:
+      - https://127.0.0.1:8006/api2/json
+      - https://127.0.0.2:8006/api2/json
+    fingerprint: "` + strings.Repeat("AB", 32) + `"
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+`))
+	assert.EqualError(t, err, "cluster #1: fingerprint matches only one certificate, use fingerprints with several urls")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The kinds of the Proxmox API errors, use errors.Is to check the error returned by ParseError.
+// ErrClusterUnavailable is the kind of the connection errors.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrStorageFull      = errors.New("storage is full")
+	ErrLockTimeout      = errors.New("lock timeout")
+	ErrVMLocked         = errors.New("vm is locked")
+	ErrInvalidParameter = errors.New("invalid parameter")
+)
+
+// Error is the error of the Proxmox API with its kind.
+type Error struct {
+	// Kind is one of the Err* errors
+	Kind error
+	// StatusCode is the HTTP status code of the response, zero if it is unknown
+	StatusCode int
+
+	err error
+}
+
+// Error implements error, the message is the original error message.
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the kind and the original error.
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.err}
+}
+
+// httpStatusRegexp matches the HTTP status in the error message, pxapi returns the status line as the error: "500 got timeout"
+var httpStatusRegexp = regexp.MustCompile(`(?:^|: )([1-5][0-9]{2}) [^ ]`)
+
+// networkErrors are the messages of the errors when the Proxmox API cannot be reached
+var networkErrors = []string{
+	"connection refused",
+	"connection reset by peer",
+	"no such host",
+	"no route to host",
+	"i/o timeout",
+	"TLS handshake timeout",
+}
+
+// errorMessages maps the messages of Proxmox and pxapi to the error kinds, the first match wins.
+var errorMessages = []struct {
+	kind    error
+	message string
+}{
+	{ErrVMLocked, "VM is locked"},
+	{ErrLockTimeout, "got timeout"},
+	{ErrPermissionDenied, "Permission check failed"},
+	{ErrInvalidParameter, "Parameter verification failed"},
+	{ErrStorageFull, "insufficient free space"},
+	{ErrStorageFull, "not enough free space"},
+	{ErrStorageFull, "No space left on device"},
+	{ErrStorageFull, "out of space"},
+	{ErrStorageFull, "exceeds quota"},
+	{ErrNotFound, "does not exist"},
+	{ErrNotFound, "no such"},
+	// pxapi: vm 'name' not found
+	{ErrNotFound, "' not found"},
+}
+
+// ParseError returns the typed error of the Proxmox API error.
+// pxapi formats most errors as strings, so the kind is detected by the HTTP status and the message.
+// The error is returned as is, if the kind is unknown or the error is already parsed.
+func ParseError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var perr *Error
+	if errors.As(err, &perr) {
+		return err
+	}
+
+	msg := err.Error()
+	statusCode := 0
+
+	if m := httpStatusRegexp.FindStringSubmatch(msg); m != nil {
+		statusCode, _ = strconv.Atoi(m[1]) //nolint:errcheck
+	}
+
+	kind := errorKind(statusCode, msg)
+	if kind == nil {
+		return err
+	}
+
+	return &Error{Kind: kind, StatusCode: statusCode, err: err}
+}
+
+func errorKind(statusCode int, msg string) error {
+	if strings.Contains(msg, ErrClusterUnavailable.Error()) {
+		return ErrClusterUnavailable
+	}
+
+	for _, e := range networkErrors {
+		if strings.Contains(msg, e) {
+			return ErrClusterUnavailable
+		}
+	}
+
+	for _, m := range errorMessages {
+		if strings.Contains(msg, m.message) {
+			return m.kind
+		}
+	}
+
+	switch statusCode {
+	case 400:
+		return ErrInvalidParameter
+	case 401, 403:
+		return ErrPermissionDenied
+	case 404:
+		return ErrNotFound
+	case 502, 503, 504, 595, 596:
+		// The proxy cannot connect to the node, it is the same as the unavailable cluster for the request
+		return ErrClusterUnavailable
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestParseError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		msg          string
+		err          error
+		expectedKind error
+		expectedCode int
+	}{
+		{
+			msg: "Unknown",
+			err: fmt.Errorf("500 Internal Server Error"),
+		},
+		{
+			msg:          "NotFound",
+			err:          fmt.Errorf("failed to get vm config: 500 Configuration file 'nodes/pve-1/qemu-server/100.conf' does not exist"),
+			expectedKind: proxmox.ErrNotFound,
+			expectedCode: 500,
+		},
+		{
+			msg:          "VMNotFound",
+			err:          fmt.Errorf("vm 'node-1' not found"),
+			expectedKind: proxmox.ErrNotFound,
+		},
+		{
+			msg:          "PermissionDenied",
+			err:          fmt.Errorf("403 Permission check failed (/vms/100, VM.Config.Disk)"),
+			expectedKind: proxmox.ErrPermissionDenied,
+			expectedCode: 403,
+		},
+		{
+			msg:          "Unauthorized",
+			err:          fmt.Errorf("401 authentication failure"),
+			expectedKind: proxmox.ErrPermissionDenied,
+			expectedCode: 401,
+		},
+		{
+			msg:          "StorageFull",
+			err:          fmt.Errorf(`500 lvcreate 'pve/vm-9999-pvc-123' error: Volume group "pve" has insufficient free space`),
+			expectedKind: proxmox.ErrStorageFull,
+			expectedCode: 500,
+		},
+		{
+			msg:          "LockTimeout",
+			err:          fmt.Errorf("500 can't lock file '/var/lock/qemu-server/lock-100.conf' - got timeout"),
+			expectedKind: proxmox.ErrLockTimeout,
+			expectedCode: 500,
+		},
+		{
+			msg:          "VMLocked",
+			err:          fmt.Errorf("500 VM is locked (backup)"),
+			expectedKind: proxmox.ErrVMLocked,
+			expectedCode: 500,
+		},
+		{
+			msg:          "InvalidParameter",
+			err:          fmt.Errorf("400 Parameter verification failed."),
+			expectedKind: proxmox.ErrInvalidParameter,
+			expectedCode: 400,
+		},
+		{
+			msg:          "NoConnection",
+			err:          fmt.Errorf("596 Connection timed out"),
+			expectedKind: proxmox.ErrClusterUnavailable,
+			expectedCode: 596,
+		},
+		{
+			msg:          "NetworkError",
+			err:          fmt.Errorf(`Get "https://127.0.0.1:8006/api2/json/nodes": dial tcp 127.0.0.1:8006: connect: connection refused`),
+			expectedKind: proxmox.ErrClusterUnavailable,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(fmt.Sprint(testCase.msg), func(t *testing.T) {
+			t.Parallel()
+
+			err := proxmox.ParseError(testCase.err)
+			assert.Equal(t, testCase.err.Error(), err.Error())
+			assert.ErrorIs(t, err, testCase.err)
+
+			var perr *proxmox.Error
+			if testCase.expectedKind == nil {
+				assert.False(t, errors.As(err, &perr))
+
+				return
+			}
+
+			assert.True(t, errors.As(err, &perr))
+			assert.ErrorIs(t, err, testCase.expectedKind)
+			assert.Equal(t, testCase.expectedCode, perr.StatusCode)
+			assert.Equal(t, err, proxmox.ParseError(err))
+		})
+	}
+
+	assert.Nil(t, proxmox.ParseError(nil))
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// queueWaitInfo and queueWaitWarning are the queue wait times of the request, which are logged
+	queueWaitInfo    = 100 * time.Millisecond
+	queueWaitWarning = 5 * time.Second
+)
+
+// limiter limits the request rate and the number of concurrent requests to the Proxmox API of one region.
+type limiter struct {
+	name  string
+	rate  *rate.Limiter
+	slots chan struct{}
+}
+
+// newLimiter returns the limiter of the region, or nil if the region has no limits.
+func newLimiter(cfg *ClusterConfig) *limiter {
+	if cfg.RateLimit <= 0 && cfg.MaxConcurrency <= 0 {
+		return nil
+	}
+
+	l := &limiter{name: cfg.Region}
+
+	if cfg.RateLimit > 0 {
+		burst := cfg.RateBurst
+		if burst <= 0 {
+			burst = int(math.Ceil(cfg.RateLimit))
+		}
+
+		l.rate = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+
+	if cfg.MaxConcurrency > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrency)
+	}
+
+	return l
+}
+
+// wait blocks until the request can be sent, the returned func releases the concurrency slot of the request.
+func (l *limiter) wait(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+
+	if l.rate != nil {
+		r := l.rate.Reserve()
+
+		if delay := r.Delay(); delay > 0 {
+			timer := time.NewTimer(delay)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				r.Cancel()
+
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	release := func() {}
+
+	if l.slots != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case l.slots <- struct{}{}:
+		}
+
+		var once sync.Once
+
+		release = func() { once.Do(func() { <-l.slots }) }
+	}
+
+	if wait := time.Since(start); wait >= queueWaitWarning {
+		klog.Warningf("proxmox: request to cluster %s waited %s in the queue", l.name, wait)
+	} else if wait >= queueWaitInfo {
+		klog.V(4).Infof("proxmox: request to cluster %s waited %s in the queue", l.name, wait)
+	}
+
+	return release, nil
+}
+
+// readBody reads the response body into memory and closes it, so the concurrency slot is released by the transport.
+// pxapi does not close the body if it fails to read it, so the slot cannot wait for Close.
+func readBody(resp *http.Response) error {
+	defer resp.Body.Close() //nolint:errcheck
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func newLimitedCluster(t *testing.T, limits string) *proxmox.Cluster {
+	t.Helper()
+
+	cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+` + limits))
+	assert.Nil(t, err)
+
+	client, err := proxmox.NewCluster(&cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	return client
+}
+
+func TestRateLimit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		httpmock.NewJsonResponderOrPanic(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}}))
+
+	client := newLimitedCluster(t, `    rate_limit: 0.1
+    rate_burst: 1
+`)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cl, err = client.GetProxmoxCluster(ctx, "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			close(started)
+			<-unblock
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}})
+		},
+	)
+
+	client := newLimitedCluster(t, `    max_concurrency: 1
+`)
+
+	done := make(chan error)
+
+	go func() {
+		cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+		if err == nil {
+			_, err = cl.GetVersion()
+		}
+
+		done <- err
+	}()
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cl, err := client.GetProxmoxCluster(ctx, "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(unblock)
+	assert.Nil(t, <-done)
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+}
+
+type failingBody struct{}
+
+func (failingBody) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func (failingBody) Close() error { return nil }
+
+func TestMaxConcurrencyBodyReadError(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	calls := 0
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return &http.Response{StatusCode: http.StatusOK, Body: failingBody{}, Request: req}, nil
+			}
+
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"data": map[string]interface{}{"version": "8.1.3"}})
+		},
+	)
+
+	client := newLimitedCluster(t, `    max_concurrency: 1
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cl, err := client.GetProxmoxCluster(ctx, "cluster-1")
+	assert.Nil(t, err)
+
+	// The slot of the failed read is released, so the retry and the next request are sent
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, httpmock.GetTotalCallCount())
+}
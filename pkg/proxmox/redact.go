@@ -0,0 +1,136 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const redacted = "<redacted>"
+
+// secrets are the passwords, token secrets and tickets of the clusters, they are removed from the log messages.
+var secrets = struct {
+	sync.RWMutex
+	replacer *strings.Replacer
+	values   map[string]struct{}
+}{values: map[string]struct{}{}}
+
+// addSecret registers the values which must not be logged.
+func addSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, v := range values {
+		if v != "" {
+			secrets.values[v] = struct{}{}
+		}
+	}
+
+	updateReplacer()
+}
+
+// removeSecret forgets the values, it is called when the ticket is replaced by a new one.
+func removeSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, v := range values {
+		delete(secrets.values, v)
+	}
+
+	updateReplacer()
+}
+
+// updateReplacer must be called with the lock held.
+func updateReplacer() {
+	pairs := make([]string, 0, len(secrets.values)*2)
+	for v := range secrets.values {
+		pairs = append(pairs, v, redacted)
+	}
+
+	secrets.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces the credentials of the clusters in the string.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	if len(secrets.values) == 0 {
+		return s
+	}
+
+	return secrets.replacer.Replace(s)
+}
+
+// LogFilter removes the credentials of the clusters from the log messages, use klog.SetLogFilter to install it.
+type LogFilter struct{}
+
+// Filter implements klog.LogFilter.
+// The arguments are redacted one by one, so klog joins them as without the filter, by fmt.Sprint or fmt.Sprintln.
+func (LogFilter) Filter(args []interface{}) []interface{} {
+	values := make([]interface{}, len(args))
+
+	for i, v := range args {
+		switch v := v.(type) {
+		case string:
+			values[i] = Redact(v)
+		case error, fmt.Stringer:
+			// fmt.Sprint adds the spaces only between the operands which are not strings, so the errors stay non-strings
+			values[i] = redactedValue{s: Redact(fmt.Sprint(v))}
+		default:
+			values[i] = v
+		}
+	}
+
+	return values
+}
+
+// redactedValue is the redacted error or fmt.Stringer, it is a struct and not a string for fmt.Sprint.
+type redactedValue struct {
+	s string
+}
+
+// String implements fmt.Stringer.
+func (v redactedValue) String() string {
+	return v.s
+}
+
+// FilterF implements klog.LogFilter.
+func (LogFilter) FilterF(format string, args []interface{}) (string, []interface{}) {
+	return "%s", []interface{}{Redact(fmt.Sprintf(format, args...))}
+}
+
+// FilterS implements klog.LogFilter.
+func (LogFilter) FilterS(msg string, keysAndValues []interface{}) (string, []interface{}) {
+	values := make([]interface{}, len(keysAndValues))
+
+	for i, v := range keysAndValues {
+		switch v := v.(type) {
+		case string:
+			values[i] = Redact(v)
+		case error, fmt.Stringer:
+			values[i] = Redact(fmt.Sprint(v))
+		default:
+			values[i] = v
+		}
+	}
+
+	return Redact(msg), values
+}
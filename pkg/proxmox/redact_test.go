@@ -0,0 +1,47 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestLogFilter(t *testing.T) {
+	_ = newPasswordCluster(t)
+
+	// klog joins the arguments of Info by fmt.Sprint and of Infoln by fmt.Sprintln, the filter keeps it
+	args := proxmox.LogFilter{}.Filter([]interface{}{"failed to ", "connect with ", "login-password", 3, errors.New("login-password"), 4})
+	assert.Equal(t, "failed to connect with <redacted>3 <redacted> 4", fmt.Sprint(args...))
+	assert.Equal(t, "failed to  connect with  <redacted> 3 <redacted> 4\n", fmt.Sprintln(args...))
+
+	args = proxmox.LogFilter{}.Filter([]interface{}{"version: ", "8.1.3"})
+	assert.Equal(t, "version: 8.1.3", fmt.Sprint(args...))
+
+	format, args := proxmox.LogFilter{}.FilterF("login with %s", []interface{}{"login-password"})
+	assert.Equal(t, "%s", format)
+	assert.Equal(t, []interface{}{"login with <redacted>"}, args)
+
+	msg, kv := proxmox.LogFilter{}.FilterS("login-password", []interface{}{"password", "login-password", "attempt", 1})
+	assert.Equal(t, "<redacted>", msg)
+	assert.Equal(t, []interface{}{"password", "<redacted>", "attempt", 1}, kv)
+}
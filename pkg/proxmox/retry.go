@@ -0,0 +1,236 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// retryAttempts is the maximum number of attempts of the idempotent requests
+	retryAttempts = 4
+	// retryBaseDelay is the delay before the first retry, it is doubled with each attempt
+	retryBaseDelay = 200 * time.Millisecond
+	// retryMaxDelay is the maximum delay between the attempts
+	retryMaxDelay = 5 * time.Second
+
+	// breakerThreshold is the number of consecutive failures which opens the circuit breaker
+	breakerThreshold = 5
+	// breakerCooldown is the time the circuit breaker stays open before the next probe request
+	breakerCooldown = 30 * time.Second
+)
+
+// ErrClusterUnavailable is returned without calling the Proxmox API, when the circuit breaker of the region is open.
+var ErrClusterUnavailable = errors.New("proxmox cluster is unavailable")
+
+// retry sends the request, the idempotent requests are retried with exponential backoff and jitter.
+// The request fails fast when the circuit breaker of the region is open.
+func (t *contextTransport) retry(base http.RoundTripper, req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req.Method) {
+		attempts = retryAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !t.breaker.allow() {
+			return nil, ErrClusterUnavailable
+		}
+
+		release, err := t.limiter.wait(t.ctx)
+		if err != nil {
+			t.breaker.release()
+
+			return nil, err
+		}
+
+		ep, r := t.endpoints.get(req)
+
+		resp, err := base.RoundTrip(r)
+		if err == nil {
+			if err = readBody(resp); err != nil {
+				resp = nil
+			}
+		}
+
+		release()
+
+		if t.ctx.Err() != nil {
+			// The request is cancelled, it says nothing about the cluster
+			t.breaker.release()
+
+			return resp, err
+		}
+
+		retry := isRetryable(resp, err)
+		unavailable := isUnavailable(resp, err)
+		t.breaker.record(!unavailable)
+
+		if unavailable {
+			// The next attempt and the next requests go to the other endpoint of the region
+			t.endpoints.setHealth(ep, false)
+		}
+
+		if !retry || attempt >= attempts {
+			return resp, err
+		}
+
+		delay := backoff(attempt)
+
+		klog.V(4).Infof("proxmox: %s %s%s failed, retrying in %s: %s", req.Method, r.URL.Host, req.URL.Path, delay, describe(resp, err))
+
+		if resp != nil {
+			resp.Body.Close() //nolint:errcheck
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-t.ctx.Done():
+			timer.Stop()
+
+			return nil, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// isRetryable returns true if the request failed because of a transient error.
+// Proxmox returns 500 with the reason "got timeout" if it cannot lock the config,
+// and 595/596 if the proxy cannot connect to the node.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 595, 596:
+		return true
+	case http.StatusInternalServerError:
+		return strings.Contains(resp.Status, "timeout")
+	}
+
+	return false
+}
+
+// isUnavailable returns true if the cluster did not handle the request.
+func isUnavailable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// backoff returns the delay before the next attempt, the jitter is up to the half of the delay.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)) //nolint:gosec
+}
+
+func describe(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+
+	return resp.Status
+}
+
+// circuitBreaker fails the requests fast after many consecutive failures.
+// After the cooldown one probe request is allowed, the breaker is closed if it succeeds.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{name: name}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	b.probing = true
+
+	return true
+}
+
+// release allows the next probe request, if the result of the request is unknown.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if success {
+		if b.failures >= breakerThreshold {
+			klog.Infof("proxmox: cluster %s is available again", b.name)
+		}
+
+		b.failures = 0
+
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= breakerThreshold {
+		if b.failures == breakerThreshold {
+			klog.Warningf("proxmox: cluster %s is unavailable, requests fail fast for %s", b.name, breakerCooldown)
+		}
+
+		b.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
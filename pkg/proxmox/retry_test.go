@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestRetry(t *testing.T) {
+	cfg, err := newClusterEnv()
+	assert.Nil(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		httpmock.ResponderFromMultipleResponses([]*http.Response{
+			httpmock.NewStringResponse(http.StatusServiceUnavailable, ""),
+			httpmock.NewStringResponse(595, ""),
+			httpmock.NewStringResponse(http.StatusOK, `{"data":{"version":"8.1.3"}}`),
+		}),
+	)
+
+	httpmock.RegisterResponder("POST", "https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config",
+		httpmock.NewStringResponder(http.StatusServiceUnavailable, ""),
+	)
+
+	client, err := proxmox.NewCluster(cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	version, err := cl.GetVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"version": "8.1.3"}, version["data"])
+
+	// Requests which change the state are not retried
+	err = cl.Post(map[string]interface{}{"name": "vm"}, "/nodes/pve-1/qemu/100/config")
+	assert.NotNil(t, err)
+
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 3, calls["GET https://127.0.0.1:8006/api2/json/version"])
+	assert.Equal(t, 1, calls["POST https://127.0.0.1:8006/api2/json/nodes/pve-1/qemu/100/config"])
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg, err := newClusterEnv()
+	assert.Nil(t, err)
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.1:8006/api2/json/version",
+		httpmock.NewStringResponder(http.StatusBadGateway, ""),
+	)
+
+	httpmock.RegisterResponder("GET", "https://127.0.0.2:8006/api2/json/version",
+		httpmock.NewStringResponder(http.StatusOK, `{"data":{"version":"8.1.3"}}`),
+	)
+
+	client, err := proxmox.NewCluster(cfg, &http.Client{})
+	assert.Nil(t, err)
+
+	cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, proxmox.ErrClusterUnavailable)
+
+	_, err = cl.GetVersion()
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, proxmox.ErrClusterUnavailable)
+
+	// The breaker is shared by all clients of the region
+	cl, err = client.GetProxmoxCluster(context.Background(), "cluster-1")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.ErrorIs(t, err, proxmox.ErrClusterUnavailable)
+
+	calls := httpmock.GetCallCountInfo()
+	assert.Equal(t, 5, calls["GET https://127.0.0.1:8006/api2/json/version"])
+
+	// Other regions are not affected
+	cl, err = client.GetProxmoxCluster(context.Background(), "cluster-2")
+	assert.Nil(t, err)
+
+	_, err = cl.GetVersion()
+	assert.Nil(t, err)
+}
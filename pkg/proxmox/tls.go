@@ -0,0 +1,133 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readTLSFiles reads the CA bundle and checks the fingerprint of the config.
+func readTLSFiles(c *ClusterConfig) error {
+	if c.CAFile != "" {
+		data, err := os.ReadFile(filepath.Clean(c.CAFile))
+		if err != nil {
+			return fmt.Errorf("failed to read ca_file: %v", err)
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return fmt.Errorf("ca_file %s has no PEM certificates", c.CAFile)
+		}
+
+		c.caData = data
+	}
+
+	if c.Fingerprint != "" && len(c.Fingerprints) > 0 {
+		return fmt.Errorf("fingerprint and fingerprints are mutually exclusive")
+	}
+
+	if c.Fingerprint != "" && len(c.URLs) > 1 {
+		return fmt.Errorf("fingerprint matches only one certificate, use fingerprints with several urls")
+	}
+
+	for _, fingerprint := range c.pinnedFingerprints() {
+		if _, err := parseFingerprint(fingerprint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pinnedFingerprints returns the fingerprints of the accepted certificates, or nil if the certificates are not pinned.
+func (c *ClusterConfig) pinnedFingerprints() []string {
+	if len(c.Fingerprints) > 0 {
+		return c.Fingerprints
+	}
+
+	if c.Fingerprint != "" {
+		return []string{c.Fingerprint}
+	}
+
+	return nil
+}
+
+// parseFingerprint returns the SHA-256 digest of the fingerprint, the hex digits can be separated by colons.
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	digest, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("fingerprint must be the SHA-256 digest of the certificate in hex")
+	}
+
+	return digest, nil
+}
+
+// newTLSConfig returns the TLS config of the region, or nil if the defaults are used.
+// The insecure option skips the verification of the certificate chain, the fingerprints are checked anyway,
+// so the self-signed certificate of Proxmox can be pinned.
+func newTLSConfig(cfg *ClusterConfig) *tls.Config {
+	fingerprints := cfg.pinnedFingerprints()
+
+	if !cfg.Insecure && cfg.caData == nil && len(fingerprints) == 0 {
+		return nil
+	}
+
+	tlsconf := &tls.Config{
+		InsecureSkipVerify: cfg.Insecure, //nolint:gosec
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if cfg.caData != nil {
+		tlsconf.RootCAs = x509.NewCertPool()
+		tlsconf.RootCAs.AppendCertsFromPEM(cfg.caData)
+	}
+
+	if len(fingerprints) > 0 {
+		digests := make([][]byte, 0, len(fingerprints))
+
+		for _, fingerprint := range fingerprints {
+			// The fingerprints are checked by ReadCloudConfig, the invalid one matches no certificate
+			digest, _ := parseFingerprint(fingerprint) //nolint:errcheck
+			digests = append(digests, digest)
+		}
+
+		tlsconf.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("proxmox cluster %s has no certificate", cfg.Region)
+			}
+
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+
+			for _, digest := range digests {
+				if subtle.ConstantTimeCompare(sum[:], digest) == 1 {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("certificate fingerprint %s of proxmox cluster %s does not match the pinned ones", hex.EncodeToString(sum[:]), cfg.Region)
+		}
+	}
+
+	return tlsconf
+}
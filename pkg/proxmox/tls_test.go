@@ -0,0 +1,180 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxmox_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sergelogvinov/proxmox-csi-plugin/pkg/proxmox"
+)
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"data":{"version":"8.1.3"}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	fingerprint := strings.ToUpper(hex.EncodeToString(sum[:]))
+	wrongFingerprint := strings.Repeat("00", sha256.Size)
+
+	tests := []struct {
+		msg           string
+		options       string
+		expectedError string
+	}{
+		{
+			msg:           "SystemCA",
+			expectedError: "certificate signed by unknown authority",
+		},
+		{
+			msg:     "CAFile",
+			options: "ca_file: " + caFile,
+		},
+		{
+			msg:     "CAFileAndFingerprint",
+			options: "ca_file: " + caFile + "\n    fingerprint: " + fingerprint,
+		},
+		{
+			msg:     "InsecureAndFingerprint",
+			options: "insecure: true\n    fingerprint: " + fingerprint,
+		},
+		{
+			msg:           "WrongFingerprint",
+			options:       "insecure: true\n    fingerprint: " + wrongFingerprint,
+			expectedError: "does not match the pinned ones",
+		},
+		{
+			msg:     "Fingerprints",
+			options: "insecure: true\n    fingerprints:\n      - " + wrongFingerprint + "\n      - " + fingerprint,
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: ` + server.URL + `/api2/json
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+    ` + testCase.options))
+			assert.Nil(t, err)
+
+			client, err := proxmox.NewCluster(&cfg, nil)
+			assert.Nil(t, err)
+
+			cl, err := client.GetProxmoxCluster(context.Background(), "cluster-1")
+			assert.Nil(t, err)
+
+			_, err = cl.GetVersion()
+			if testCase.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.NotNil(t, err)
+				assert.Contains(t, err.Error(), testCase.expectedError)
+			}
+		})
+	}
+}
+
+func TestReadCloudConfigTLS(t *testing.T) {
+	t.Parallel()
+
+	invalidCAFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.Nil(t, os.WriteFile(invalidCAFile, []byte("not a certificate"), 0o600))
+
+	tests := []struct {
+		msg           string
+		options       string
+		expectedError string
+	}{
+		{
+			msg:           "MissingCAFile",
+			options:       "ca_file: /non-existent/ca.pem",
+			expectedError: "cluster #1: failed to read ca_file: open /non-existent/ca.pem: no such file or directory",
+		},
+		{
+			msg:           "InvalidCAFile",
+			options:       "ca_file: " + invalidCAFile,
+			expectedError: "cluster #1: ca_file " + invalidCAFile + " has no PEM certificates",
+		},
+		{
+			msg:           "ShortFingerprint",
+			options:       "fingerprint: AB:CD",
+			expectedError: "cluster #1: fingerprint must be the SHA-256 digest of the certificate in hex",
+		},
+		{
+			msg:     "Fingerprint",
+			options: "fingerprint: " + strings.TrimSuffix(strings.Repeat("AB:", sha256.Size), ":"),
+		},
+		{
+			msg:           "ShortFingerprints",
+			options:       "fingerprints:\n      - " + strings.Repeat("AB", sha256.Size) + "\n      - AB:CD",
+			expectedError: "cluster #1: fingerprint must be the SHA-256 digest of the certificate in hex",
+		},
+		{
+			msg:           "FingerprintAndFingerprints",
+			options:       "fingerprint: " + strings.Repeat("AB", sha256.Size) + "\n    fingerprints:\n      - " + strings.Repeat("CD", sha256.Size),
+			expectedError: "cluster #1: fingerprint and fingerprints are mutually exclusive",
+		},
+	}
+
+	for _, testCase := range tests {
+		testCase := testCase
+
+		t.Run(testCase.msg, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := proxmox.ReadCloudConfig(strings.NewReader(`
+clusters:
+  - url: https://127.0.0.1:8006/api2/json
+    token_id: "user!token-id"
+    token_secret: "secret"
+    region: cluster-1
+    ` + testCase.options))
+
+			if testCase.expectedError == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, testCase.expectedError)
+			}
+		})
+	}
+}
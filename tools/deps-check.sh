@@ -39,6 +39,8 @@ set -o errexit
 # go mod pkg/csi/node.go
 /sbin/fstrim -V
 /sbin/cryptsetup -V
+/sbin/mount.nfs -V
+/sbin/mount.cifs -V
 
 # This utils are using by
 # go mod k8s.io/cloud-provider-openstack/pkg/util/mount
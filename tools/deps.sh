@@ -76,6 +76,12 @@ cp /usr/sbin/xfs* ${DEST}/usr/sbin/
 # go mod pkg/csi/node.go
 copy_deps /sbin/fstrim
 copy_deps /sbin/cryptsetup
+# from pkg nfs-common and cifs-utils - NFS and CIFS shares
+copy_deps /sbin/mount.nfs
+copy_deps /sbin/mount.nfs4
+copy_deps /etc/netconfig
+copy_deps /sbin/mount.cifs
+copy_deps /sbin/mount.smb3
 ARCH=$(uname -m)
 mkdir -p ${DEST}/lib/${ARCH}-linux-gnu && cp /lib/${ARCH}-linux-gnu/libgcc_s.so.* ${DEST}/lib/${ARCH}-linux-gnu/
 